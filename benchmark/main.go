@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -99,13 +100,110 @@ var testCases = map[string]string{
 	`,
 }
 
+// spawnTestCase fires bullets with parameters which vanish shortly, to measure
+// allocations of firing in steady state.
+var spawnTestCase = `
+<bulletml>
+	<action label="top">
+		<repeat>
+			<times>` + strconv.Itoa(loop) + `</times>
+			<action>
+				<repeat>
+					<times>20</times>
+					<action>
+						<fireRef label="f">
+							<param>$loop.index * 18</param>
+						</fireRef>
+					</action>
+				</repeat>
+				<wait>1</wait>
+			</action>
+		</repeat>
+	</action>
+	<fire label="f">
+		<direction type="absolute">$1</direction>
+		<bulletRef label="b">
+			<param>$1</param>
+		</bulletRef>
+	</fire>
+	<bullet label="b">
+		<speed>2</speed>
+		<action>
+			<wait>10</wait>
+			<changeDirection>
+				<direction type="relative">$1</direction>
+				<term>10</term>
+			</changeDirection>
+			<wait>20</wait>
+			<vanish />
+		</action>
+	</bullet>
+</bulletml>
+`
+
+func spawn() {
+	bml, err := bulletml.Load(bytes.NewReader([]byte(spawnTestCase)))
+	if err != nil {
+		panic(err)
+	}
+
+	fireCount := 0
+
+	manager := bulletml.NewManager(nil)
+	if _, err := manager.NewRunner(bml, &bulletml.NewRunnerOptions{
+		OnBulletFired: func(bulletml.BulletRunner, *bulletml.FireContext) {
+			fireCount++
+		},
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 0 },
+	}); err != nil {
+		panic(err)
+	}
+
+	// Warm up until the pools are filled
+	for i := 0; i < 100; i++ {
+		if err := manager.Update(); err != nil {
+			panic(err)
+		}
+	}
+
+	fireCount = 0
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now().UnixNano()
+
+	for i := 0; i < loop-200; i++ {
+		if err := manager.Update(); err != nil {
+			panic(err)
+		}
+	}
+
+	end := time.Now().UnixNano()
+	runtime.ReadMemStats(&after)
+
+	json.NewEncoder(os.Stdout).Encode(map[string]any{
+		"testCase":      "spawn",
+		"fireCount":     fireCount,
+		"loopCount":     loop - 200,
+		"elapsedNano":   end - start,
+		"allocsPerFire": float64(after.Mallocs-before.Mallocs) / float64(fireCount),
+	})
+}
+
 func main() {
+	if os.Args[1] == "spawn" {
+		spawn()
+		return
+	}
+
 	source, exists := testCases[os.Args[1]]
 	if !exists {
 		var tc []string
 		for k, _ := range testCases {
 			tc = append(tc, k)
 		}
+		tc = append(tc, "spawn")
 		panic("Please choose from: " + strings.Join(tc, ", "))
 	}
 
@@ -0,0 +1,247 @@
+package bulletml
+
+import "math"
+
+// Circle is a circle used for hit tests.
+type Circle struct {
+	X, Y, Radius float64
+}
+
+// ColliderOptions contains options for NewCollider function.
+type ColliderOptions struct {
+	// CellSize is the size of the grid cells. It should be about the size of bullets.
+	// 32 is used if 0.
+	CellSize float64
+
+	// Radius returns the radius of the bullet. It can read metadata stored in ManagedBullet.Data,
+	// e.g. the value of FireContext.FloatAttr("radius", 4) set in ManagerOptions.OnBulletAdded.
+	// DefaultRadius is used for all bullets if nil.
+	Radius func(*ManagedBullet) float64
+
+	// DefaultRadius is the radius of the bullets if Radius is nil.
+	DefaultRadius float64
+}
+
+// Collider finds bullets which hit or graze circles, using a uniform grid
+// so that a test does not check all the bullets.
+//
+// Bullets are added after every update and tested with the segments they moved in the update,
+// so fast bullets can't pass through targets.
+type Collider struct {
+	opts ColliderOptions
+	dt   float64
+
+	entries []colliderEntry
+	heads   []int32
+	links   []colliderLink
+	stamp   uint32
+
+	// grazed holds the generations of the runners which have grazed
+	grazed map[*runner]uint64
+}
+
+type colliderEntry struct {
+	bullet         *ManagedBullet
+	x0, y0, x1, y1 float64
+	radius         float64
+	stamp          uint32
+}
+
+type colliderLink struct {
+	entry, next int32
+	cx, cy      int32
+}
+
+// NewCollider creates a new Collider.
+func NewCollider(opts *ColliderOptions) *Collider {
+	c := &Collider{
+		grazed: make(map[*runner]uint64),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.CellSize <= 0 {
+		c.opts.CellSize = 32
+	}
+	return c
+}
+
+// Reset removes all the bullets. dt is the ticks the bullets moved in the last update,
+// i.e. the value passed to UpdateDelta. If it is 0, only the current positions are tested.
+func (c *Collider) Reset(dt float64) {
+	for i := range c.entries {
+		c.entries[i] = colliderEntry{}
+	}
+	c.entries = c.entries[:0]
+	c.links = c.links[:0]
+	c.heads = c.heads[:0]
+	c.dt = dt
+
+	for r, gen := range c.grazed {
+		if r.generation != gen || r.bullet.vanished {
+			delete(c.grazed, r)
+		}
+	}
+}
+
+// Add adds the bullet. Vanished bullets are ignored.
+// Hosts which do not use Manager can wrap their runners with ManagedBullet.
+func (c *Collider) Add(b *ManagedBullet) {
+	if b.Runner.Vanished() {
+		return
+	}
+
+	x, y := b.Runner.Position()
+	vx, vy := b.Runner.Velocity()
+
+	radius := c.opts.DefaultRadius
+	if c.opts.Radius != nil {
+		radius = c.opts.Radius(b)
+	}
+
+	c.entries = append(c.entries, colliderEntry{
+		bullet: b,
+		x0:     x - float64(vx*c.dt),
+		y0:     y - float64(vy*c.dt),
+		x1:     x,
+		y1:     y,
+		radius: radius,
+	})
+	c.heads = c.heads[:0]
+}
+
+// AddManager adds all the bullets in the manager.
+func (c *Collider) AddManager(m *Manager) {
+	m.Each(c.Add)
+}
+
+// HitTest returns a bullet which hits the circle, or nil if there is none.
+func (c *Collider) HitTest(circle Circle) *ManagedBullet {
+	var hit *ManagedBullet
+	c.query(circle, func(e *colliderEntry) bool {
+		hit = e.bullet
+		return false
+	})
+	return hit
+}
+
+// HitTestAll appends all the bullets which hit the circle to dst and returns it.
+func (c *Collider) HitTestAll(circle Circle, dst []*ManagedBullet) []*ManagedBullet {
+	c.query(circle, func(e *colliderEntry) bool {
+		dst = append(dst, e.bullet)
+		return true
+	})
+	return dst
+}
+
+// Graze appends the bullets which hit the circle for the first time to dst and returns it.
+// The circle is usually larger than the one for HitTest. Each bullet is reported only once
+// in its life, so the host can count grazes by calling this in every loop.
+func (c *Collider) Graze(circle Circle, dst []*ManagedBullet) []*ManagedBullet {
+	c.query(circle, func(e *colliderEntry) bool {
+		r := e.bullet.Runner.(*runner)
+		if gen, exists := c.grazed[r]; !exists || gen != r.generation {
+			c.grazed[r] = r.generation
+			dst = append(dst, e.bullet)
+		}
+		return true
+	})
+	return dst
+}
+
+// query calls f for each bullet which hits the circle until f returns false.
+func (c *Collider) query(circle Circle, f func(*colliderEntry) bool) {
+	if len(c.entries) == 0 {
+		return
+	}
+	if len(c.heads) == 0 {
+		c.build()
+	}
+
+	c.stamp++
+	if c.stamp == 0 {
+		for i := range c.entries {
+			c.entries[i].stamp = 0
+		}
+		c.stamp = 1
+	}
+
+	minCx, minCy := c.cell(circle.X-circle.Radius, circle.Y-circle.Radius)
+	maxCx, maxCy := c.cell(circle.X+circle.Radius, circle.Y+circle.Radius)
+	for cy := minCy; cy <= maxCy; cy++ {
+		for cx := minCx; cx <= maxCx; cx++ {
+			for i := c.heads[c.bucket(cx, cy)]; i >= 0; i = c.links[i].next {
+				l := &c.links[i]
+				if l.cx != cx || l.cy != cy {
+					continue
+				}
+
+				e := &c.entries[l.entry]
+				if e.stamp == c.stamp {
+					continue
+				}
+				e.stamp = c.stamp
+
+				if e.hits(circle) && !f(e) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// build puts the entries into the cells which their segments cover.
+func (c *Collider) build() {
+	n := 1
+	for n < len(c.entries)*2 {
+		n *= 2
+	}
+	if cap(c.heads) < n {
+		c.heads = make([]int32, n)
+	}
+	c.heads = c.heads[:n]
+	for i := range c.heads {
+		c.heads[i] = -1
+	}
+	c.links = c.links[:0]
+
+	for i, e := range c.entries {
+		minCx, minCy := c.cell(math.Min(e.x0, e.x1)-e.radius, math.Min(e.y0, e.y1)-e.radius)
+		maxCx, maxCy := c.cell(math.Max(e.x0, e.x1)+e.radius, math.Max(e.y0, e.y1)+e.radius)
+		for cy := minCy; cy <= maxCy; cy++ {
+			for cx := minCx; cx <= maxCx; cx++ {
+				b := c.bucket(cx, cy)
+				c.links = append(c.links, colliderLink{
+					entry: int32(i),
+					next:  c.heads[b],
+					cx:    cx,
+					cy:    cy,
+				})
+				c.heads[b] = int32(len(c.links) - 1)
+			}
+		}
+	}
+}
+
+func (c *Collider) cell(x, y float64) (int32, int32) {
+	return int32(math.Floor(x / c.opts.CellSize)), int32(math.Floor(y / c.opts.CellSize))
+}
+
+func (c *Collider) bucket(cx, cy int32) int {
+	h := uint32(cx)*0x9e3779b1 ^ uint32(cy)*0x85ebca77
+	return int(h & uint32(len(c.heads)-1))
+}
+
+// hits returns whether the segment of the bullet comes within the radii of the circle.
+func (e *colliderEntry) hits(circle Circle) bool {
+	dx, dy := e.x1-e.x0, e.y1-e.y0
+	t := 0.0
+	if l := float64(dx*dx) + float64(dy*dy); l > 0 {
+		t = (float64((circle.X-e.x0)*dx) + float64((circle.Y-e.y0)*dy)) / l
+		t = math.Max(0, math.Min(1, t))
+	}
+
+	px, py := e.x0+float64(dx*t)-circle.X, e.y0+float64(dy*t)-circle.Y
+	r := e.radius + circle.Radius
+	return float64(px*px)+float64(py*py) <= float64(r*r)
+}
@@ -0,0 +1,65 @@
+package bulletml
+
+import "testing"
+
+func TestColliderHitTest(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <fire><direction type="absolute">90</direction><speed>10</speed><bullet/></fire>
+</action>
+</bulletml>`
+
+	m := NewManager(nil)
+	_, err := m.NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := m.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bullet *ManagedBullet
+	m.Each(func(b *ManagedBullet) { bullet = b })
+	if bullet == nil {
+		t.Fatal("no bullet is fired")
+	}
+	// The bullet moved from (x - 10, y) to (x, y) in the last update
+	x, y := bullet.Runner.Position()
+
+	tests := []struct {
+		name   string
+		dt     float64
+		circle Circle
+		hit    bool
+	}{
+		{"current position", 1, Circle{X: x, Y: y, Radius: 1}, true},
+		{"previous position", 1, Circle{X: x - 10, Y: y, Radius: 1}, true},
+		{"passed through", 1, Circle{X: x - 5, Y: y, Radius: 1}, true},
+		{"passed through without dt", 0, Circle{X: x - 5, Y: y, Radius: 1}, false},
+		{"touching the segment", 1, Circle{X: x - 5, Y: y + 2.9, Radius: 1}, true},
+		{"beside the segment", 1, Circle{X: x - 5, Y: y + 3.1, Radius: 1}, false},
+		{"ahead", 1, Circle{X: x + 3.1, Y: y, Radius: 1}, false},
+		{"behind", 1, Circle{X: x - 13.1, Y: y, Radius: 1}, false},
+		{"large circle", 1, Circle{X: x, Y: y + 50, Radius: 50}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollider(&ColliderOptions{CellSize: 4, DefaultRadius: 2})
+			c.Reset(tt.dt)
+			c.AddManager(m)
+
+			if hit := c.HitTest(tt.circle) != nil; hit != tt.hit {
+				t.Errorf("HitTest = %v, want %v", hit, tt.hit)
+			}
+			if n := len(c.HitTestAll(tt.circle, nil)); (n == 1) != tt.hit || n > 1 {
+				t.Errorf("HitTestAll returns %d bullets, want hit %v", n, tt.hit)
+			}
+		})
+	}
+}
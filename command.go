@@ -0,0 +1,161 @@
+package bulletml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"sync"
+)
+
+// CustomCommandSpec defines a custom command element registered by RegisterCommand.
+type CustomCommandSpec struct {
+	// Params are the names of the attributes whose values are expressions.
+	// They are evaluated every time the command runs and passed to Execute.
+	Params []string
+
+	// Decode is called when the element is loaded. The returned value is stored
+	// in CustomCommand.Value. It can be nil.
+	Decode func(*CustomCommand) (any, error)
+
+	// [Required] Execute runs the command.
+	Execute func(*CustomCommandContext) error
+}
+
+// CustomCommandContext contains context data of a custom command execution.
+type CustomCommandContext struct {
+	// Runner is the runner executing the command. It is the one returned by NewRunner for top-level actions.
+	Runner Runner
+
+	// Command is the element being executed.
+	Command *CustomCommand
+
+	// Params contains the evaluated values of CustomCommandSpec.Params.
+	Params map[string]float64
+
+	wait    float64
+	waiting bool
+}
+
+// Wait suspends the actions for the ticks after the command, in the same way as <wait>.
+func (c *CustomCommandContext) Wait(ticks float64) {
+	c.wait = ticks
+	c.waiting = true
+}
+
+var (
+	customCommandsMutex sync.RWMutex
+	customCommands      = make(map[string]*CustomCommandSpec)
+)
+
+var builtinCommands = []string{"repeat", "fire", "fireRef", "changeSpeed", "changeDirection", "accel", "homing", "wait", "vanish", "action", "actionRef"}
+
+// RegisterCommand registers a custom command element which can be used in <action>.
+// Documents using it must be loaded after the registration.
+// It panics if the name is a built-in element or is already registered.
+func RegisterCommand(name string, spec *CustomCommandSpec) {
+	if spec == nil || spec.Execute == nil {
+		panic("bulletml: Execute is required for custom command <" + name + ">")
+	}
+	if isIn(name, builtinCommands) {
+		panic("bulletml: <" + name + "> is a built-in element")
+	}
+
+	customCommandsMutex.Lock()
+	defer customCommandsMutex.Unlock()
+
+	if _, exists := customCommands[name]; exists {
+		panic("bulletml: custom command <" + name + "> is already registered")
+	}
+	customCommands[name] = spec
+}
+
+func lookUpCustomCommand(name string) (*CustomCommandSpec, bool) {
+	customCommandsMutex.RLock()
+	defer customCommandsMutex.RUnlock()
+
+	spec, exists := customCommands[name]
+	return spec, exists
+}
+
+// CustomCommand is an element registered by RegisterCommand.
+type CustomCommand struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr
+	InnerXML string
+
+	// Value is the value returned by CustomCommandSpec.Decode.
+	Value any
+
+	spec           *CustomCommandSpec
+	compiledParams []ast.Expr
+	parentNode     node
+}
+
+func (c *CustomCommand) prepare() error {
+	c.compiledParams = c.compiledParams[:0]
+	for _, name := range c.spec.Params {
+		expr, exists := c.Attr(name)
+		if !exists {
+			return newBulletmlError(fmt.Sprintf("<%s> element requires '%s' attribute", c.XMLName.Local, name), c)
+		}
+
+		compiled, err := compileExpr(expr, c)
+		if err != nil {
+			return err
+		}
+		c.compiledParams = append(c.compiledParams, compiled)
+	}
+
+	return nil
+}
+
+func (c *CustomCommand) parent() node {
+	return c.parentNode
+}
+
+func (c *CustomCommand) xmlName() string {
+	return c.XMLName.Local
+}
+
+// Attr returns the value of the attribute.
+func (c *CustomCommand) Attr(name string) (string, bool) {
+	return findAttr(c.Attrs, name)
+}
+
+func (c *CustomCommand) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	spec, exists := lookUpCustomCommand(start.Name.Local)
+	if !exists {
+		return fmt.Errorf("Unexpected element <%s>", start.Name.Local)
+	}
+
+	var raw struct {
+		InnerXML string `xml:",innerxml"`
+	}
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+
+	c.XMLName = start.Name
+	c.Attrs = cloneSlice(start.Attr)
+	c.InnerXML = raw.InnerXML
+	c.spec = spec
+
+	if spec.Decode != nil {
+		v, err := spec.Decode(c)
+		if err != nil {
+			return err
+		}
+		c.Value = v
+	}
+
+	return nil
+}
+
+func (c *CustomCommand) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = c.XMLName
+	start.Attr = c.Attrs
+
+	return e.EncodeElement(struct {
+		InnerXML string `xml:",innerxml"`
+	}{c.InnerXML}, start)
+}
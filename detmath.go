@@ -0,0 +1,274 @@
+package bulletml
+
+import "math"
+
+// mathFuncs holds the trigonometric functions used by runners.
+type mathFuncs struct {
+	sin, cos func(float64) float64
+	atan2    func(y, x float64) float64
+}
+
+var (
+	standardMath = &mathFuncs{
+		sin:   math.Sin,
+		cos:   math.Cos,
+		atan2: math.Atan2,
+	}
+
+	deterministicMath = &mathFuncs{
+		sin:   dsin,
+		cos:   dcos,
+		atan2: datan2,
+	}
+)
+
+// The functions below are ports of the Cephes based implementations in the math package.
+// Every product is rounded by an explicit conversion so that the compiler never fuses
+// it into an FMA instruction, which makes the results bit-identical on all architectures.
+
+var dsinCoefficients = [...]float64{
+	1.58962301576546568060e-10,
+	-2.50507477628578072866e-8,
+	2.75573136213857245213e-6,
+	-1.98412698295895385996e-4,
+	8.33333333332211858878e-3,
+	-1.66666666666666307295e-1,
+}
+
+var dcosCoefficients = [...]float64{
+	-1.13585365213876817300e-11,
+	2.08757008419747316778e-9,
+	-2.75573141792967388112e-7,
+	2.48015872888517045348e-5,
+	-1.38888888888730564116e-3,
+	4.16666666666665929218e-2,
+}
+
+const (
+	dpi4A = 7.85398125648498535156e-1
+	dpi4B = 3.77489470793079817668e-8
+	dpi4C = 2.69515142907905952645e-15
+
+	// Arguments larger than this are reduced by math.Mod, which is exact,
+	// before they are converted to an integer octant.
+	dreduceThreshold = 1 << 29
+)
+
+// dreduce maps x >= 0 to an octant j and the remainder z in [-Pi/4, Pi/4].
+func dreduce(x float64) (uint64, float64) {
+	if x >= dreduceThreshold {
+		x = math.Mod(x, 2*math.Pi)
+	}
+
+	j := uint64(float64(x * (4 / math.Pi)))
+	y := float64(j)
+	if j&1 == 1 {
+		j++
+		y++
+	}
+	j &= 7
+	z := ((x - float64(y*dpi4A)) - float64(y*dpi4B)) - float64(y*dpi4C)
+
+	return j, z
+}
+
+func dpoly(c *[6]float64, zz float64) float64 {
+	p := c[0]
+	for _, v := range c[1:] {
+		p = float64(p*zz) + v
+	}
+	return p
+}
+
+func dsinKernel(z, zz float64) float64 {
+	return z + float64(float64(z*zz)*dpoly(&dsinCoefficients, zz))
+}
+
+func dcosKernel(zz float64) float64 {
+	return (1.0 - float64(0.5*zz)) + float64(float64(zz*zz)*dpoly(&dcosCoefficients, zz))
+}
+
+func dsin(x float64) float64 {
+	if x == 0 || math.IsNaN(x) {
+		return x
+	}
+	if math.IsInf(x, 0) {
+		return math.NaN()
+	}
+
+	sign := false
+	if x < 0 {
+		x = -x
+		sign = true
+	}
+
+	j, z := dreduce(x)
+	if j > 3 {
+		sign = !sign
+		j -= 4
+	}
+
+	zz := float64(z * z)
+	var y float64
+	if j == 1 || j == 2 {
+		y = dcosKernel(zz)
+	} else {
+		y = dsinKernel(z, zz)
+	}
+	if sign {
+		y = -y
+	}
+	return y
+}
+
+func dcos(x float64) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return math.NaN()
+	}
+
+	sign := false
+	x = math.Abs(x)
+
+	j, z := dreduce(x)
+	if j > 3 {
+		j -= 4
+		sign = !sign
+	}
+	if j > 1 {
+		sign = !sign
+	}
+
+	zz := float64(z * z)
+	var y float64
+	if j == 1 || j == 2 {
+		y = dsinKernel(z, zz)
+	} else {
+		y = dcosKernel(zz)
+	}
+	if sign {
+		y = -y
+	}
+	return y
+}
+
+func dxatan(x float64) float64 {
+	const (
+		p0 = -8.750608600031904122785e-01
+		p1 = -1.615753718733365076637e+01
+		p2 = -7.500855792314704667340e+01
+		p3 = -1.228866684490136173410e+02
+		p4 = -6.485021904942025371773e+01
+		q0 = +2.485846490142306297962e+01
+		q1 = +1.650270098316988542046e+02
+		q2 = +4.328810604912902668951e+02
+		q3 = +4.853903996359136964868e+02
+		q4 = +1.945506571482613964425e+02
+	)
+	z := float64(x * x)
+	p := float64(p0*z) + p1
+	p = float64(p*z) + p2
+	p = float64(p*z) + p3
+	p = float64(p*z) + p4
+	q := z + q0
+	q = float64(q*z) + q1
+	q = float64(q*z) + q2
+	q = float64(q*z) + q3
+	q = float64(q*z) + q4
+	z = float64(z*p) / q
+	return float64(x*z) + x
+}
+
+func dsatan(x float64) float64 {
+	const (
+		morebits = 6.123233995736765886130e-17
+		tan3pio8 = 2.41421356237309504880
+	)
+	if x <= 0.66 {
+		return dxatan(x)
+	}
+	if x > tan3pio8 {
+		return math.Pi/2 - dxatan(1/x) + morebits
+	}
+	return math.Pi/4 + dxatan((x-1)/(x+1)) + 0.5*morebits
+}
+
+func datan(x float64) float64 {
+	if x == 0 {
+		return x
+	}
+	if x > 0 {
+		return dsatan(x)
+	}
+	return -dsatan(-x)
+}
+
+func datan2(y, x float64) float64 {
+	switch {
+	case math.IsNaN(y) || math.IsNaN(x):
+		return math.NaN()
+	case y == 0:
+		if x >= 0 && !math.Signbit(x) {
+			return math.Copysign(0, y)
+		}
+		return math.Copysign(math.Pi, y)
+	case x == 0:
+		return math.Copysign(math.Pi/2, y)
+	case math.IsInf(x, 0):
+		if math.IsInf(x, 1) {
+			if math.IsInf(y, 0) {
+				return math.Copysign(math.Pi/4, y)
+			}
+			return math.Copysign(0, y)
+		}
+		if math.IsInf(y, 0) {
+			return math.Copysign(3*math.Pi/4, y)
+		}
+		return math.Copysign(math.Pi, y)
+	case math.IsInf(y, 0):
+		return math.Copysign(math.Pi/2, y)
+	}
+
+	q := datan(y / x)
+	if x < 0 {
+		if q <= 0 {
+			return q + math.Pi
+		}
+		return q - math.Pi
+	}
+	return q
+}
+
+// dexp2 is a port of exp2 of Cephes, which the math package doesn't use.
+func dexp2(x float64) float64 {
+	const (
+		p0 = 2.30933477057345225087e-2
+		p1 = 2.02020656693165307700e1
+		p2 = 1.51390680115615096133e3
+		q0 = 2.33184211722314911771e2
+		q1 = 4.36821166879210612817e3
+	)
+	switch {
+	case math.IsNaN(x) || math.IsInf(x, 1):
+		return x
+	case math.IsInf(x, -1):
+		return 0
+	case x > 1024:
+		return math.Inf(1)
+	case x < -1075:
+		return 0
+	}
+
+	// 2^x = 2^n * 2^f with |f| <= 0.5
+	n := math.Floor(x + 0.5)
+	f := x - n
+
+	ff := float64(f * f)
+	p := float64(ff*p0) + p1
+	p = float64(p*ff) + p2
+	p = float64(f * p)
+	q := ff + q0
+	q = float64(q*ff) + q1
+	y := 1 + math.Ldexp(p/(q-p), 1)
+
+	return math.Ldexp(y, int(n))
+}
@@ -0,0 +1,113 @@
+package bulletml
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"os"
+	"testing"
+)
+
+// The golden values below must not change on any architecture or Go version.
+
+func TestDeterministicMathGolden(t *testing.T) {
+	h := fnv.New64a()
+	var buf []byte
+	for i := -1000; i <= 1000; i++ {
+		x := float64(i) * 0.0123
+		buf = binary.LittleEndian.AppendUint64(buf[:0], math.Float64bits(dsin(x)))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(dcos(x)))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(datan2(x, 1.5-x)))
+		h.Write(buf)
+	}
+
+	if got, want := h.Sum64(), uint64(0x3d1f35e1bb4587ad); got != want {
+		t.Errorf("hash of results = %016x, want %016x", got, want)
+	}
+}
+
+func TestDeterministicMathAccuracy(t *testing.T) {
+	for i := -1000; i <= 1000; i++ {
+		x := float64(i) * 0.0123
+		if d := math.Abs(dsin(x) - math.Sin(x)); d > 1e-15 {
+			t.Errorf("dsin(%v) differs from math.Sin by %v", x, d)
+		}
+		if d := math.Abs(dcos(x) - math.Cos(x)); d > 1e-15 {
+			t.Errorf("dcos(%v) differs from math.Cos by %v", x, d)
+		}
+		if d := math.Abs(datan2(x, 1.5-x) - math.Atan2(x, 1.5-x)); d > 1e-15 {
+			t.Errorf("datan2(%v, %v) differs from math.Atan2 by %v", x, 1.5-x, d)
+		}
+	}
+}
+
+func TestBuiltinEasingsGolden(t *testing.T) {
+	h := fnv.New64a()
+	var buf []byte
+	for _, name := range builtinEasings {
+		f, _ := lookUpEasing(name)
+		for i := 0; i <= 1000; i++ {
+			buf = binary.LittleEndian.AppendUint64(buf[:0], math.Float64bits(f(float64(i)/1000)))
+			h.Write(buf)
+		}
+	}
+
+	if got, want := h.Sum64(), uint64(0xd4458d588f803ea8); got != want {
+		t.Errorf("hash of results = %016x, want %016x", got, want)
+	}
+}
+
+func TestDeterministicMathReplayGolden(t *testing.T) {
+	tests := []struct {
+		file string
+		hash uint64
+	}{
+		{"demo/3-way.xml", 0xdd8ed20300252d40},
+		{"demo/circulate.xml", 0x8956820d4094d38b},
+		{"demo/homing.xml", 0x9e78b2d1a55745c4},
+		{"demo/recursion.xml", 0xf244d630adcc36c3},
+		{"demo/sine-curve.xml", 0x22266483a52c4a31},
+		{"demo/vortex.xml", 0x736d925132ff57a7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			bml, err := Load(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec, err := NewRecorder(bml, &NewRunnerOptions{
+				OnBulletFired:         func(BulletRunner, *FireContext) {},
+				CurrentShootPosition:  func() (float64, float64) { return 200, 100 },
+				CurrentTargetPosition: func() (float64, float64) { return 250, 400 },
+				Seed:                  1,
+				Rank:                  0.5,
+				DeterministicMath:     true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 600; i++ {
+				if err := rec.Update(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rp := rec.Replay()
+			if got := rp.Hash(rp.Len() - 1); got != tt.hash {
+				t.Errorf("hash of bullet positions = %016x, want %016x", got, tt.hash)
+			}
+			if err := rp.Verify(bml); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
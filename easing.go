@@ -0,0 +1,121 @@
+package bulletml
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// EasingFunc maps the progress of a change in [0, 1] to the ratio of the change applied,
+// which is usually 0 at 0 and 1 at 1.
+type EasingFunc func(t float64) float64
+
+var (
+	easingsMutex sync.RWMutex
+	easings      = map[string]EasingFunc{
+		"linear":    func(t float64) float64 { return t },
+		"inQuad":    func(t float64) float64 { return t * t },
+		"outQuad":   func(t float64) float64 { return 1 - float64((1-t)*(1-t)) },
+		"inOutQuad": inOut(func(t float64) float64 { return t * t }),
+		"inCubic":   func(t float64) float64 { return t * t * t },
+		"outCubic":  func(t float64) float64 { return 1 - float64((1-t)*(1-t)*(1-t)) },
+		"inOutCubic": inOut(func(t float64) float64 {
+			return t * t * t
+		}),
+		"inSine":    func(t float64) float64 { return 1 - dcos(t*math.Pi/2) },
+		"outSine":   func(t float64) float64 { return dsin(t * math.Pi / 2) },
+		"inOutSine": func(t float64) float64 { return (1 - dcos(t*math.Pi)) / 2 },
+		"inExpo":    inExpo,
+		"outExpo":   func(t float64) float64 { return 1 - inExpo(1-t) },
+		"inOutExpo": inOut(inExpo),
+	}
+	builtinEasings = sortedKeys(easings)
+)
+
+func inExpo(t float64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	return dexp2(float64(10*t) - 10)
+}
+
+// inOut makes an ease-in-out function from an ease-in function.
+func inOut(in EasingFunc) EasingFunc {
+	return func(t float64) float64 {
+		if t < 0.5 {
+			return in(t*2) / 2
+		}
+		return 1 - float64(in((1-t)*2)/2)
+	}
+}
+
+// RegisterEasing registers an easing function which can be used in 'ease' attributes
+// of <changeSpeed>, <changeDirection> and <accel>, e.g. ease="outBounce".
+// It must be registered before runners of documents using it are created.
+// It panics if the name is a built-in easing or is already registered.
+func RegisterEasing(name string, f EasingFunc) {
+	if f == nil {
+		panic("bulletml: easing function '" + name + "' is nil")
+	}
+	if isIn(name, builtinEasings) {
+		panic("bulletml: '" + name + "' is a built-in easing")
+	}
+
+	easingsMutex.Lock()
+	defer easingsMutex.Unlock()
+
+	if _, exists := easings[name]; exists {
+		panic("bulletml: easing '" + name + "' is already registered")
+	}
+	easings[name] = f
+}
+
+func lookUpEasing(name string) (EasingFunc, bool) {
+	easingsMutex.RLock()
+	defer easingsMutex.RUnlock()
+
+	f, exists := easings[name]
+	return f, exists
+}
+
+func prepareEasing(name string, n node) (EasingFunc, error) {
+	if name == "" {
+		return nil, nil
+	}
+	f, exists := lookUpEasing(name)
+	if !exists {
+		return nil, newBulletmlError(fmt.Sprintf("Unknown easing '%s'", name), n)
+	}
+	return f, nil
+}
+
+// easing is the state of a change which is eased instead of linear.
+// A change of up to four values, e.g. the components of acceleration,
+// goes from 'from' to 'from + span' in term ticks from startTick.
+// The term may be fractional, in which case the change ends on the tick after the last whole tick.
+type easing struct {
+	name       string
+	fn         EasingFunc
+	startTick  int
+	term       float64
+	from, span [4]float64
+}
+
+// start sets up the easing for a change over term ticks which ends at the tick until.
+// It disables the easing if fn is nil.
+func (e *easing) start(name string, fn EasingFunc, ticks, until int, term float64) {
+	*e = easing{}
+	if fn != nil && until > ticks {
+		*e = easing{name: name, fn: fn, startTick: ticks, term: term}
+	}
+}
+
+func (e *easing) active() bool {
+	return e.fn != nil
+}
+
+// value returns the i-th value at the end of the tick, which is before the change ends.
+func (e *easing) value(i, ticks int) float64 {
+	k := ticks - e.startTick + 1
+	return e.from[i] + float64(e.span[i]*e.fn(float64(k)/e.term))
+}
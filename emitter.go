@@ -0,0 +1,72 @@
+package bulletml
+
+import (
+	"fmt"
+	"math"
+)
+
+// Emitter is a named point which bullets are fired from, e.g. a gun of a boss.
+// It is chosen by the 'emitter' attribute of <fire> or <action>.
+type Emitter struct {
+	// [Required] Position tells the runner where the emitter is.
+	Position func() (float64, float64)
+
+	// Direction tells the runner the direction of the emitter in BulletML degrees,
+	// which relative directions of bullets fired from it are based on.
+	// The direction of the shooter is used if nil.
+	Direction func() float64
+}
+
+// checkEmitters returns an error if the document uses emitters which are not given.
+func checkEmitters(bulletML *BulletML, emitters map[string]*Emitter) error {
+	var err error
+	eachNode(bulletML, func(n node) {
+		var name string
+		switch n := n.(type) {
+		case *Fire:
+			name = n.Emitter
+		case *Action:
+			name = n.Emitter
+		}
+		if name == "" || err != nil {
+			return
+		}
+
+		if e, exists := emitters[name]; !exists || e == nil || e.Position == nil {
+			err = newBulletmlError(fmt.Sprintf("Emitter '%s' is not in NewRunnerOptions.Emitters", name), n)
+		}
+	})
+	return err
+}
+
+// emitter returns the emitter which fires the bullet of the <fire> element.
+// It is given by the element or the innermost action which has the 'emitter' attribute.
+// Only the top-level actions use emitters.
+func (p *actionProcess) emitter(fire *Fire) (string, *Emitter) {
+	r := p.runner
+	if r.host == nil || r.config.opts.Emitters == nil {
+		return "", nil
+	}
+
+	name := fire.Emitter
+	for i := len(r.stack) - 1; i >= 0 && name == ""; i-- {
+		name = r.stack[i].action.Emitter
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	return name, r.config.opts.Emitters[name]
+}
+
+// origin returns the position and the direction which the bullet is fired from.
+func (p *actionProcess) origin(e *Emitter) (x, y, dir float64) {
+	x, y, dir = p.runner.bullet.x, p.runner.bullet.y, p.runner.bullet.direction
+	if e != nil {
+		x, y = e.Position()
+		if e.Direction != nil {
+			dir = (e.Direction() - 90) * math.Pi / 180
+		}
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package bulletml
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEmitters(t *testing.T) {
+	emitters := map[string]*Emitter{
+		"left": {
+			Position: func() (float64, float64) { return -50, 0 },
+		},
+		"right": {
+			Position:  func() (float64, float64) { return 50, 0 },
+			Direction: func() float64 { return 180 },
+		},
+	}
+
+	tests := []struct {
+		name string
+		top  string
+
+		// bullet is the index of the fired bullet to check
+		bullet     int
+		x, y, dir  float64
+		wantsError bool
+	}{
+		{
+			name: "shooter",
+			top:  `<fire><direction type="absolute">90</direction><bullet/></fire>`,
+			x:    0, y: 0, dir: 90,
+		},
+		{
+			name: "fire",
+			top:  `<fire emitter="left"><direction type="absolute">90</direction><bullet/></fire>`,
+			x:    -50, y: 0, dir: 90,
+		},
+		{
+			name: "action",
+			top:  `<action emitter="left"><repeat><times>1</times><action><fire><direction type="absolute">90</direction><bullet/></fire></action></repeat></action>`,
+			x:    -50, y: 0, dir: 90,
+		},
+		{
+			name: "fire overrides action",
+			top:  `<action emitter="left"><fire emitter="right"><direction type="absolute">90</direction><bullet/></fire></action>`,
+			x:    50, y: 0, dir: 90,
+		},
+		{
+			name: "action reference",
+			top:  `<actionRef label="gun"/>`,
+			x:    50, y: 0, dir: 90,
+		},
+		{
+			name: "relative to emitter",
+			top:  `<fire emitter="right"><direction type="relative">90</direction><bullet/></fire>`,
+			x:    50, y: 0, dir: 270,
+		},
+		{
+			name: "aim from emitter",
+			top:  `<fire emitter="left"><direction type="aim">0</direction><bullet/></fire>`,
+			x:    -50, y: 0, dir: 180 - math.Atan2(50, 100)*180/math.Pi,
+		},
+		{
+			name: "bullets fire from themselves",
+			top: `<fire emitter="left"><direction type="absolute">90</direction><speed>0</speed>
+  <bullet><action><fire emitter="right"><direction type="absolute">180</direction><bullet/></fire></action></bullet>
+</fire>`,
+			bullet: 1,
+			x:      -50, y: 0, dir: 180,
+		},
+		{
+			name:       "missing emitter",
+			top:        `<fire emitter="center"><bullet/></fire>`,
+			wantsError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `<bulletml>
+<action label="top">` + tt.top + `</action>
+<action label="gun" emitter="right"><fire><direction type="absolute">90</direction><bullet/></fire></action>
+</bulletml>`
+
+			// fired holds the positions and the directions of the bullets when they are fired
+			var bullets []BulletRunner
+			var fired [][3]float64
+			r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+				OnBulletFired: func(b BulletRunner, _ *FireContext) {
+					x, y := b.Position()
+					bullets = append(bullets, b)
+					fired = append(fired, [3]float64{x, y, b.Direction()})
+				},
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+				Emitters:              emitters,
+			})
+			if tt.wantsError {
+				if err == nil {
+					t.Error("NewRunner succeeds, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 3 && len(bullets) <= tt.bullet; i++ {
+				if err := r.Update(); err != nil {
+					t.Fatal(err)
+				}
+				for j, n := 0, len(bullets); j < n; j++ {
+					if err := bullets[j].Update(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if len(bullets) <= tt.bullet {
+				t.Fatalf("%d bullets are fired, want more than %d", len(bullets), tt.bullet)
+			}
+
+			x, y, dir := fired[tt.bullet][0], fired[tt.bullet][1], fired[tt.bullet][2]
+			if math.Abs(x-tt.x) > 1e-9 || math.Abs(y-tt.y) > 1e-9 {
+				t.Errorf("bullet is fired at (%v, %v), want (%v, %v)", x, y, tt.x, tt.y)
+			}
+			if d := math.Remainder(dir-tt.dir, 360); math.Abs(d) > 1e-9 {
+				t.Errorf("bullet is fired in direction %v, want %v", dir, tt.dir)
+			}
+		})
+	}
+}
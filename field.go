@@ -0,0 +1,52 @@
+package bulletml
+
+import "math"
+
+// Field is a force field added by Manager.AddField.
+type Field struct {
+	accel func(x, y float64) (float64, float64)
+}
+
+// AddField adds a force field which accelerates all bullets in the manager, e.g. wind,
+// gravity or a black hole. f returns the acceleration (ax, ay) per tick at (x, y), which
+// accumulates in the bullet velocity. The shooters are not affected.
+// If Workers is not 0, f is called from the workers and must be safe for concurrent use.
+// While the manager has fields, PackedStorage is not used because bullets don't move straight.
+func (m *Manager) AddField(f func(x, y float64) (float64, float64)) *Field {
+	field := &Field{accel: f}
+	m.fields = append(m.fields, field)
+
+	for _, r := range m.packed.runners {
+		if r != nil {
+			r.unpack()
+		}
+	}
+	m.compact()
+
+	return field
+}
+
+// RemoveField removes the force field added by AddField.
+// Bullets keep the velocity given by the field.
+func (m *Manager) RemoveField(f *Field) {
+	for i, _f := range m.fields {
+		if _f == f {
+			m.fields = append(m.fields[:i], m.fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// applyFields accelerates the bullet by the fields in the step.
+func (r *runner) applyFields(fields []*Field, step float64) {
+	var ax, ay float64
+	for _, f := range fields {
+		x, y := f.accel(r.bullet.x, r.bullet.y)
+		ax += x
+		ay += y
+	}
+
+	r.bullet.fieldVx += float64(ax * step)
+	r.bullet.fieldVy += float64(ay * step)
+	r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
+}
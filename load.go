@@ -119,16 +119,20 @@ func (b *BulletML) xmlName() string {
 }
 
 type Bullet struct {
-	XMLName      xml.Name           `xml:"bullet"`
-	Label        string             `xml:"label,attr,omitempty"`
-	Direction    *Option[Direction] `xml:"direction,omitempty"`
-	Speed        *Option[Speed]     `xml:"speed,omitempty"`
-	ActionOrRefs []any              `xml:",any"`
-	Comment      string             `xml:",comment"`
-	parentNode   node               `xml:"-"`
+	XMLName       xml.Name            `xml:"bullet"`
+	Label         string              `xml:"label,attr,omitempty"`
+	Direction     *Option[Direction]  `xml:"direction,omitempty"`
+	Speed         *Option[Speed]      `xml:"speed,omitempty"`
+	ActionOrRefs  []any               `xml:",any"`
+	Attrs         []xml.Attr          `xml:",any,attr"`
+	Comment       string              `xml:",comment"`
+	compiledAttrs map[string]ast.Expr `xml:"-"`
+	parentNode    node                `xml:"-"`
 }
 
 func (b *Bullet) prepare() error {
+	b.compiledAttrs = compileAttrs(b.Attrs, b)
+
 	if d, exists := b.Direction.Get(); exists {
 		d.parentNode = b
 		if err := d.prepare(); err != nil {
@@ -177,6 +181,8 @@ func (b *Bullet) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	for _, attr := range start.Attr {
 		if attr.Name.Local == "label" {
 			b.Label = attr.Value
+		} else {
+			b.Attrs = append(b.Attrs, attr)
 		}
 	}
 
@@ -231,6 +237,7 @@ func (b *Bullet) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 type Action struct {
 	XMLName    xml.Name `xml:"action"`
 	Label      string   `xml:"label,attr,omitempty"`
+	Emitter    string   `xml:"emitter,attr,omitempty"`
 	Commands   []any    `xml:",any"`
 	Comment    string   `xml:",comment"`
 	parentNode node     `xml:"-"`
@@ -269,6 +276,11 @@ func (a *Action) prepare() error {
 			if err := c.prepare(); err != nil {
 				return err
 			}
+		case *Homing:
+			c.parentNode = a
+			if err := c.prepare(); err != nil {
+				return err
+			}
 		case *Wait:
 			c.parentNode = a
 			if err := c.prepare(); err != nil {
@@ -289,6 +301,11 @@ func (a *Action) prepare() error {
 			if err := c.prepare(); err != nil {
 				return err
 			}
+		case *CustomCommand:
+			c.parentNode = a
+			if err := c.prepare(); err != nil {
+				return err
+			}
 		default:
 			return newBulletmlError(fmt.Sprintf("Invalid child element of <%s>: %T", a.XMLName.Local, c), a)
 		}
@@ -309,8 +326,11 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	a.XMLName = start.Name
 
 	for _, attr := range start.Attr {
-		if attr.Name.Local == "label" {
+		switch attr.Name.Local {
+		case "label":
 			a.Label = attr.Value
+		case "emitter":
+			a.Emitter = attr.Value
 		}
 	}
 
@@ -356,6 +376,12 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 					return err
 				}
 				a.Commands = append(a.Commands, &c)
+			case "homing":
+				var h Homing
+				if err := d.DecodeElement(&h, &s); err != nil {
+					return err
+				}
+				a.Commands = append(a.Commands, &h)
 			case "accel":
 				var ac Accel
 				if err := d.DecodeElement(&ac, &s); err != nil {
@@ -387,7 +413,14 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 				}
 				a.Commands = append(a.Commands, &ac)
 			default:
-				return fmt.Errorf("Unexpected element <%s> in <action>", s.Name.Local)
+				if _, exists := lookUpCustomCommand(s.Name.Local); !exists {
+					return fmt.Errorf("Unexpected element <%s> in <action>", s.Name.Local)
+				}
+				var c CustomCommand
+				if err := d.DecodeElement(&c, &s); err != nil {
+					return err
+				}
+				a.Commands = append(a.Commands, &c)
 			}
 		}
 	}
@@ -396,17 +429,23 @@ func (a *Action) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 }
 
 type Fire struct {
-	XMLName    xml.Name           `xml:"fire"`
-	Label      string             `xml:"label,attr,omitempty"`
-	Direction  *Option[Direction] `xml:"direction,omitempty"`
-	Speed      *Option[Speed]     `xml:"speed,omitempty"`
-	Bullet     *Option[Bullet]    `xml:"bullet,omitempty"`
-	BulletRef  *Option[BulletRef] `xml:"bulletRef,omitempty"`
-	Comment    string             `xml:",comment"`
-	parentNode node               `xml:"-"`
+	XMLName       xml.Name            `xml:"fire"`
+	Label         string              `xml:"label,attr,omitempty"`
+	Emitter       string              `xml:"emitter,attr,omitempty"`
+	Direction     *Option[Direction]  `xml:"direction,omitempty"`
+	Speed         *Option[Speed]      `xml:"speed,omitempty"`
+	Bullet        *Option[Bullet]     `xml:"bullet,omitempty"`
+	BulletRef     *Option[BulletRef]  `xml:"bulletRef,omitempty"`
+	Offset        *Option[Offset]     `xml:"offset,omitempty"`
+	Attrs         []xml.Attr          `xml:",any,attr"`
+	Comment       string              `xml:",comment"`
+	compiledAttrs map[string]ast.Expr `xml:"-"`
+	parentNode    node                `xml:"-"`
 }
 
 func (f *Fire) prepare() error {
+	f.compiledAttrs = compileAttrs(f.Attrs, f)
+
 	if d, exists := f.Direction.Get(); exists {
 		d.parentNode = f
 		if err := d.prepare(); err != nil {
@@ -421,6 +460,13 @@ func (f *Fire) prepare() error {
 		}
 	}
 
+	if o, exists := f.Offset.Get(); exists {
+		o.parentNode = f
+		if err := o.prepare(); err != nil {
+			return err
+		}
+	}
+
 	b, bulletExists := f.Bullet.Get()
 	br, bulletRefExists := f.BulletRef.Get()
 
@@ -478,19 +524,174 @@ func (f *Fire) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	if f.BulletRef == nil {
 		f.BulletRef = &Option[BulletRef]{value: nil}
 	}
+	if f.Offset == nil {
+		f.Offset = &Option[Offset]{value: nil}
+	}
 
 	return nil
 }
 
+type OffsetType string
+
+const (
+	OffsetTypeAbsolute OffsetType = "absolute"
+	OffsetTypeRelative OffsetType = "relative"
+)
+
+// Offset is an extension element in <fire> which moves the position where the bullet appears.
+// With type 'relative', x is along the fire direction and y is 90 degrees clockwise from it.
+// If reaim is true, the aimed direction is calculated again from the moved position.
+type Offset struct {
+	XMLName    xml.Name         `xml:"offset"`
+	Type       OffsetType       `xml:"type,attr"`
+	Reaim      bool             `xml:"reaim,attr,omitempty"`
+	X          *Option[OffsetX] `xml:"x,omitempty"`
+	Y          *Option[OffsetY] `xml:"y,omitempty"`
+	Comment    string           `xml:",comment"`
+	parentNode node             `xml:"-"`
+}
+
+func (o *Offset) prepare() error {
+	if o.Type == "" {
+		o.Type = OffsetTypeRelative
+	}
+	if !isIn(o.Type, []OffsetType{OffsetTypeAbsolute, OffsetTypeRelative}) {
+		return newBulletmlError(fmt.Sprintf("Invalid 'type' attribute value of <%s> element: %s", o.XMLName.Local, o.Type), o)
+	}
+
+	if x, exists := o.X.Get(); exists {
+		x.parentNode = o
+		if err := x.prepare(); err != nil {
+			return err
+		}
+	}
+
+	if y, exists := o.Y.Get(); exists {
+		y.parentNode = o
+		if err := y.prepare(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Offset) parent() node {
+	return o.parentNode
+}
+
+func (o *Offset) xmlName() string {
+	return o.XMLName.Local
+}
+
+func (o *Offset) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type O Offset
+
+	var of O
+	if err := d.DecodeElement(&of, &start); err != nil {
+		return err
+	}
+
+	*o = Offset(of)
+
+	if o.X == nil {
+		o.X = &Option[OffsetX]{value: nil}
+	}
+	if o.Y == nil {
+		o.Y = &Option[OffsetY]{value: nil}
+	}
+
+	return nil
+}
+
+type OffsetX struct {
+	XMLName      xml.Name `xml:"x"`
+	Expr         string   `xml:",chardata"`
+	Comment      string   `xml:",comment"`
+	compiledExpr ast.Expr `xml:"-"`
+	parentNode   node     `xml:"-"`
+}
+
+func (x *OffsetX) prepare() error {
+	compiled, err := compileExpr(x.Expr, x)
+	if err != nil {
+		return err
+	}
+	x.compiledExpr = compiled
+
+	return nil
+}
+
+func (x *OffsetX) parent() node {
+	return x.parentNode
+}
+
+func (x *OffsetX) xmlName() string {
+	return x.XMLName.Local
+}
+
+type OffsetY struct {
+	XMLName      xml.Name `xml:"y"`
+	Expr         string   `xml:",chardata"`
+	Comment      string   `xml:",comment"`
+	compiledExpr ast.Expr `xml:"-"`
+	parentNode   node     `xml:"-"`
+}
+
+func (y *OffsetY) prepare() error {
+	compiled, err := compileExpr(y.Expr, y)
+	if err != nil {
+		return err
+	}
+	y.compiledExpr = compiled
+
+	return nil
+}
+
+func (y *OffsetY) parent() node {
+	return y.parentNode
+}
+
+func (y *OffsetY) xmlName() string {
+	return y.XMLName.Local
+}
+
+// RotateType is the extension attribute 'rotate' of <changeDirection>, which chooses
+// which way the bullet turns. With 'cw' or 'ccw', the angle of <direction type="relative">
+// is not limited to 180 degrees, so the bullet can turn more than once.
+type RotateType string
+
+const (
+	RotateTypeShortest         RotateType = "shortest"
+	RotateTypeClockwise        RotateType = "cw"
+	RotateTypeCounterClockwise RotateType = "ccw"
+)
+
 type ChangeDirection struct {
 	XMLName    xml.Name   `xml:"changeDirection"`
+	Rotate     RotateType `xml:"rotate,attr,omitempty"`
+	Ease       string     `xml:"ease,attr,omitempty"`
 	Direction  *Direction `xml:"direction"`
 	Term       *Term      `xml:"term"`
 	Comment    string     `xml:",comment"`
+	easing     EasingFunc `xml:"-"`
 	parentNode node       `xml:"-"`
 }
 
 func (c *ChangeDirection) prepare() error {
+	if c.Rotate == "" {
+		c.Rotate = RotateTypeShortest
+	}
+	if !isIn(c.Rotate, []RotateType{RotateTypeShortest, RotateTypeClockwise, RotateTypeCounterClockwise}) {
+		return newBulletmlError(fmt.Sprintf("Invalid 'rotate' attribute value of <%s> element: %s", c.XMLName.Local, c.Rotate), c)
+	}
+
+	easing, err := prepareEasing(c.Ease, c)
+	if err != nil {
+		return err
+	}
+	c.easing = easing
+
 	if c.Direction == nil {
 		return newBulletmlError(fmt.Sprintf("<%s> required in <%s>", getFieldXmlName(c, "Direction"), c.XMLName.Local), c)
 	}
@@ -519,14 +720,22 @@ func (c *ChangeDirection) xmlName() string {
 }
 
 type ChangeSpeed struct {
-	XMLName    xml.Name `xml:"changeSpeed"`
-	Speed      *Speed   `xml:"speed"`
-	Term       *Term    `xml:"term"`
-	Comment    string   `xml:",comment"`
-	parentNode node     `xml:"-"`
+	XMLName    xml.Name   `xml:"changeSpeed"`
+	Ease       string     `xml:"ease,attr,omitempty"`
+	Speed      *Speed     `xml:"speed"`
+	Term       *Term      `xml:"term"`
+	Comment    string     `xml:",comment"`
+	easing     EasingFunc `xml:"-"`
+	parentNode node       `xml:"-"`
 }
 
 func (c *ChangeSpeed) prepare() error {
+	easing, err := prepareEasing(c.Ease, c)
+	if err != nil {
+		return err
+	}
+	c.easing = easing
+
 	if c.Speed == nil {
 		return newBulletmlError(fmt.Sprintf("<%s> required in <%s>", getFieldXmlName(c, "Speed"), c.XMLName.Local), c)
 	}
@@ -554,16 +763,142 @@ func (c *ChangeSpeed) xmlName() string {
 	return c.XMLName.Local
 }
 
+// Homing is an extension element which turns the bullet toward the target every tick
+// by at most <turnRate> degrees for <term> ticks. <acceleration> accelerates the bullet
+// toward the target every tick while homing.
+type Homing struct {
+	XMLName      xml.Name              `xml:"homing"`
+	Term         *Term                 `xml:"term"`
+	TurnRate     *TurnRate             `xml:"turnRate"`
+	Acceleration *Option[Acceleration] `xml:"acceleration,omitempty"`
+	Comment      string                `xml:",comment"`
+	parentNode   node                  `xml:"-"`
+}
+
+func (h *Homing) prepare() error {
+	if h.Term == nil {
+		return newBulletmlError(fmt.Sprintf("<%s> required in <%s>", getFieldXmlName(h, "Term"), h.XMLName.Local), h)
+	}
+	h.Term.parentNode = h
+	if err := h.Term.prepare(); err != nil {
+		return err
+	}
+
+	if h.TurnRate == nil {
+		return newBulletmlError(fmt.Sprintf("<%s> required in <%s>", getFieldXmlName(h, "TurnRate"), h.XMLName.Local), h)
+	}
+	h.TurnRate.parentNode = h
+	if err := h.TurnRate.prepare(); err != nil {
+		return err
+	}
+
+	if a, exists := h.Acceleration.Get(); exists {
+		a.parentNode = h
+		if err := a.prepare(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Homing) parent() node {
+	return h.parentNode
+}
+
+func (h *Homing) xmlName() string {
+	return h.XMLName.Local
+}
+
+func (h *Homing) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type H Homing
+
+	var hm H
+	if err := d.DecodeElement(&hm, &start); err != nil {
+		return err
+	}
+
+	*h = Homing(hm)
+
+	if h.Acceleration == nil {
+		h.Acceleration = &Option[Acceleration]{value: nil}
+	}
+
+	return nil
+}
+
+type TurnRate struct {
+	XMLName      xml.Name `xml:"turnRate"`
+	Expr         string   `xml:",chardata"`
+	Comment      string   `xml:",comment"`
+	compiledExpr ast.Expr `xml:"-"`
+	parentNode   node     `xml:"-"`
+}
+
+func (t *TurnRate) prepare() error {
+	compiled, err := compileExpr(t.Expr, t)
+	if err != nil {
+		return err
+	}
+	t.compiledExpr = compiled
+
+	return nil
+}
+
+func (t *TurnRate) parent() node {
+	return t.parentNode
+}
+
+func (t *TurnRate) xmlName() string {
+	return t.XMLName.Local
+}
+
+type Acceleration struct {
+	XMLName      xml.Name `xml:"acceleration"`
+	Expr         string   `xml:",chardata"`
+	Comment      string   `xml:",comment"`
+	compiledExpr ast.Expr `xml:"-"`
+	parentNode   node     `xml:"-"`
+}
+
+func (a *Acceleration) prepare() error {
+	compiled, err := compileExpr(a.Expr, a)
+	if err != nil {
+		return err
+	}
+	a.compiledExpr = compiled
+
+	return nil
+}
+
+func (a *Acceleration) parent() node {
+	return a.parentNode
+}
+
+func (a *Acceleration) xmlName() string {
+	return a.XMLName.Local
+}
+
 type Accel struct {
 	XMLName    xml.Name            `xml:"accel"`
+	Ease       string              `xml:"ease,attr,omitempty"`
 	Horizontal *Option[Horizontal] `xml:"horizontal,omitempty"`
 	Vertical   *Option[Vertical]   `xml:"vertical,omitempty"`
+	Tangential *Option[Tangential] `xml:"tangential,omitempty"`
+	Normal     *Option[Normal]     `xml:"normal,omitempty"`
 	Term       *Term               `xml:"term"`
 	Comment    string              `xml:",comment"`
+	easing     EasingFunc          `xml:"-"`
 	parentNode node                `xml:"-"`
 }
 
 func (a *Accel) prepare() error {
+	easing, err := prepareEasing(a.Ease, a)
+	if err != nil {
+		return err
+	}
+	a.easing = easing
+
 	if h, exists := a.Horizontal.Get(); exists {
 		h.parentNode = a
 		if err := h.prepare(); err != nil {
@@ -578,6 +913,20 @@ func (a *Accel) prepare() error {
 		}
 	}
 
+	if t, exists := a.Tangential.Get(); exists {
+		t.parentNode = a
+		if err := t.prepare(); err != nil {
+			return err
+		}
+	}
+
+	if n, exists := a.Normal.Get(); exists {
+		n.parentNode = a
+		if err := n.prepare(); err != nil {
+			return err
+		}
+	}
+
 	if a.Term == nil {
 		return newBulletmlError(fmt.Sprintf("<%s> required in <%s>", getFieldXmlName(a, "Term"), a.XMLName.Local), a)
 	}
@@ -605,6 +954,12 @@ func (a *Accel) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	if a.Vertical == nil {
 		a.Vertical = &Option[Vertical]{value: nil}
 	}
+	if a.Tangential == nil {
+		a.Tangential = &Option[Tangential]{value: nil}
+	}
+	if a.Normal == nil {
+		a.Normal = &Option[Normal]{value: nil}
+	}
 
 	return nil
 }
@@ -741,22 +1096,28 @@ const (
 	DirectionTypeAbsolute DirectionType = "absolute"
 	DirectionTypeRelative DirectionType = "relative"
 	DirectionTypeSequence DirectionType = "sequence"
+
+	// DirectionTypePredict is an extension which aims at the point where the bullet
+	// meets the target moving at its current velocity.
+	DirectionTypePredict DirectionType = "predict"
 )
 
 type Direction struct {
-	XMLName      xml.Name      `xml:"direction"`
-	Type         DirectionType `xml:"type,attr"`
-	Expr         string        `xml:",chardata"`
-	Comment      string        `xml:",comment"`
-	compiledExpr ast.Expr      `xml:"-"`
-	parentNode   node          `xml:"-"`
+	XMLName        xml.Name      `xml:"direction"`
+	Type           DirectionType `xml:"type,attr"`
+	Target         string        `xml:"target,attr,omitempty"`
+	Expr           string        `xml:",chardata"`
+	Comment        string        `xml:",comment"`
+	compiledExpr   ast.Expr      `xml:"-"`
+	compiledTarget ast.Expr      `xml:"-"`
+	parentNode     node          `xml:"-"`
 }
 
 func (d *Direction) prepare() error {
 	if d.Type == "" {
 		d.Type = DirectionTypeAim
 	}
-	if !isIn(d.Type, []DirectionType{DirectionTypeAim, DirectionTypeAbsolute, DirectionTypeRelative, DirectionTypeSequence}) {
+	if !isIn(d.Type, []DirectionType{DirectionTypeAim, DirectionTypeAbsolute, DirectionTypeRelative, DirectionTypeSequence, DirectionTypePredict}) {
 		return newBulletmlError(fmt.Sprintf("Invalid 'type' attribute value of <%s> element: %s", d.XMLName.Local, d.Type), d)
 	}
 
@@ -766,6 +1127,19 @@ func (d *Direction) prepare() error {
 	}
 	d.compiledExpr = compiled
 
+	d.compiledTarget = nil
+	if d.Target != "" {
+		if d.Type != DirectionTypeAim && d.Type != DirectionTypePredict {
+			return newBulletmlError(fmt.Sprintf("'target' attribute of <%s> element is only for type 'aim' and 'predict'", d.XMLName.Local), d)
+		}
+
+		compiled, err := compileExpr(d.Target, d)
+		if err != nil {
+			return err
+		}
+		d.compiledTarget = compiled
+	}
+
 	return nil
 }
 
@@ -903,6 +1277,94 @@ func (v *Vertical) xmlName() string {
 	return v.XMLName.Local
 }
 
+type TangentialType string
+
+const (
+	TangentialTypeAbsolute TangentialType = "absolute"
+	TangentialTypeRelative TangentialType = "relative"
+	TangentialTypeSequence TangentialType = "sequence"
+)
+
+// Tangential is an extension element in <accel> which changes the speed added along
+// the bullet direction. It turns with the bullet unlike <horizontal>.
+type Tangential struct {
+	XMLName      xml.Name       `xml:"tangential"`
+	Type         TangentialType `xml:"type,attr"`
+	Expr         string         `xml:",chardata"`
+	Comment      string         `xml:",comment"`
+	compiledExpr ast.Expr       `xml:"-"`
+	parentNode   node           `xml:"-"`
+}
+
+func (t *Tangential) prepare() error {
+	if t.Type == "" {
+		t.Type = TangentialTypeAbsolute
+	}
+	if !isIn(t.Type, []TangentialType{TangentialTypeAbsolute, TangentialTypeRelative, TangentialTypeSequence}) {
+		return newBulletmlError(fmt.Sprintf("Invalid 'type' attribute value of <%s> element: %s", t.XMLName.Local, t.Type), t)
+	}
+
+	compiled, err := compileExpr(t.Expr, t)
+	if err != nil {
+		return err
+	}
+	t.compiledExpr = compiled
+
+	return nil
+}
+
+func (t *Tangential) parent() node {
+	return t.parentNode
+}
+
+func (t *Tangential) xmlName() string {
+	return t.XMLName.Local
+}
+
+type NormalType string
+
+const (
+	NormalTypeAbsolute NormalType = "absolute"
+	NormalTypeRelative NormalType = "relative"
+	NormalTypeSequence NormalType = "sequence"
+)
+
+// Normal is an extension element in <accel> which changes the speed added 90 degrees
+// clockwise from the bullet direction. It turns with the bullet unlike <vertical>.
+type Normal struct {
+	XMLName      xml.Name   `xml:"normal"`
+	Type         NormalType `xml:"type,attr"`
+	Expr         string     `xml:",chardata"`
+	Comment      string     `xml:",comment"`
+	compiledExpr ast.Expr   `xml:"-"`
+	parentNode   node       `xml:"-"`
+}
+
+func (n *Normal) prepare() error {
+	if n.Type == "" {
+		n.Type = NormalTypeAbsolute
+	}
+	if !isIn(n.Type, []NormalType{NormalTypeAbsolute, NormalTypeRelative, NormalTypeSequence}) {
+		return newBulletmlError(fmt.Sprintf("Invalid 'type' attribute value of <%s> element: %s", n.XMLName.Local, n.Type), n)
+	}
+
+	compiled, err := compileExpr(n.Expr, n)
+	if err != nil {
+		return err
+	}
+	n.compiledExpr = compiled
+
+	return nil
+}
+
+func (n *Normal) parent() node {
+	return n.parentNode
+}
+
+func (n *Normal) xmlName() string {
+	return n.XMLName.Local
+}
+
 type Term struct {
 	XMLName      xml.Name `xml:"term"`
 	Expr         string   `xml:",chardata"`
@@ -1142,6 +1604,18 @@ func (o *Option[T]) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	return nil
 }
 
+// compileAttrs compiles extra attributes which can be evaluated as expressions.
+// Attributes which are not expressions are skipped.
+func compileAttrs(attrs []xml.Attr, node node) map[string]ast.Expr {
+	compiled := make(map[string]ast.Expr)
+	for _, attr := range attrs {
+		if expr, err := compileExpr(attr.Value, node); err == nil {
+			compiled[attr.Name.Local] = expr
+		}
+	}
+	return compiled
+}
+
 func compileExpr(expr string, node node) (ast.Expr, error) {
 	expr = strings.ReplaceAll(expr, "$", "V_")
 	expr = strings.ReplaceAll(expr, "V_loop.", "V_loop_")
@@ -1179,7 +1653,7 @@ func compileAst(node ast.Expr, bmlNode node) (ast.Expr, error) {
 			case token.SUB:
 				return &numberValue{value: xv.value - yv.value}, nil
 			case token.MUL:
-				return &numberValue{value: xv.value * yv.value}, nil
+				return &numberValue{value: float64(xv.value * yv.value)}, nil
 			case token.QUO:
 				return &numberValue{value: xv.value / yv.value}, nil
 			case token.REM:
@@ -1189,12 +1663,7 @@ func compileAst(node ast.Expr, bmlNode node) (ast.Expr, error) {
 			}
 		}
 
-		if xok {
-			e.X = xv
-		}
-		if yok {
-			e.Y = yv
-		}
+		e.X, e.Y = x, y
 
 		return e, nil
 	case *ast.UnaryExpr:
@@ -1210,6 +1679,7 @@ func compileAst(node ast.Expr, bmlNode node) (ast.Expr, error) {
 				return nil, newBulletmlError(fmt.Sprintf("Unsupported operator: %s", e.Op.String()), bmlNode)
 			}
 		} else {
+			e.X = x
 			return e, nil
 		}
 	case *ast.BasicLit:
@@ -1260,18 +1730,20 @@ func compileAst(node ast.Expr, bmlNode node) (ast.Expr, error) {
 				return nil, newBulletmlError(fmt.Sprintf("Too few arguments for sin(): %d", len(args)), bmlNode)
 			}
 			arg := args[0] * math.Pi / 180
-			return &numberValue{value: math.Sin(arg)}, nil
+			return &numberValue{value: dsin(arg)}, nil
 		case "cos":
 			if len(args) < 1 {
 				return nil, newBulletmlError(fmt.Sprintf("Too few arguments for cos(): %d", len(args)), bmlNode)
 			}
 			arg := args[0] * math.Pi / 180
-			return &numberValue{value: math.Cos(arg)}, nil
+			return &numberValue{value: dcos(arg)}, nil
 		default:
 			return e, nil
 		}
 	case *ast.ParenExpr:
 		return compileAst(e.X, bmlNode)
+	case *ast.SelectorExpr:
+		return compileTargetValue(e, bmlNode)
 	default:
 		var buf bytes.Buffer
 		if err := format.Node(&buf, token.NewFileSet(), node); err != nil {
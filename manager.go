@@ -0,0 +1,384 @@
+package bulletml
+
+import "sync"
+
+// Rect is an axis-aligned rectangle.
+type Rect struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// Contains returns whether the point (x, y) is in the rectangle.
+func (r *Rect) Contains(x, y float64) bool {
+	return x >= r.MinX && x <= r.MaxX && y >= r.MinY && y <= r.MaxY
+}
+
+// ManagedBullet is a bullet stored in Manager.
+type ManagedBullet struct {
+	// Runner is the runner of the bullet.
+	Runner BulletRunner
+
+	// Data is arbitrary user data attached to the bullet.
+	Data any
+}
+
+// ManagerOptions contains options for NewManager function.
+type ManagerOptions struct {
+	// CullRect is the area where bullets live. Bullets which go out of it are vanished.
+	// Culling is disabled if nil.
+	CullRect *Rect
+
+	// MaxBullets is the maximum number of bullets. Bullets fired over the cap are released
+	// immediately, and OnBulletFired and OnBulletAdded are not called for them. There is no limit if 0.
+	MaxBullets int
+
+	// OnBulletAdded is called when a bullet is stored, to attach user data to it.
+	OnBulletAdded func(*ManagedBullet, *FireContext)
+
+	// PackedStorage makes the manager move bullets which have finished all actions
+	// in contiguous arrays, which is faster for many bullets. The results are the same.
+	PackedStorage bool
+
+	// Workers is the number of goroutines which update bullets in parallel.
+	// If it is not 0, OnBulletFired and OnBulletAdded for bullets fired by bullets are
+	// called after all bullets are updated, in the same order as serial updates. Each bullet
+	// of the manager gets its own random generator, so the results are the same for any number
+	// of workers including 0 and 1, which update serially. Other hooks and custom commands are
+	// called from the workers and must be safe for concurrent use.
+	Workers int
+}
+
+// Manager owns runners of many shooters and all the bullets fired from them.
+//
+// Manager updates the bullets by itself, so the host must not call Update of the bullet runners.
+// Vanished bullets are released and reused, so the host must not keep them after they vanish.
+type Manager struct {
+	opts    ManagerOptions
+	runners []Runner
+	bullets []*ManagedBullet
+	packed  packedBullets
+	free    []*ManagedBullet
+	removed []*ManagedBullet
+	fields  []*Field
+
+	// pool keeps the runners and parameters recycled by all runners of the manager
+	pool freeList
+
+	// deferring is set while bullets are updated in parallel, and dt and base
+	// are the arguments of the shards updated by the workers
+	deferring bool
+	dt        float64
+	base      int
+	errs      []error
+}
+
+type deferredFire struct {
+	runner                   *runner
+	fireParams, bulletParams parameters
+}
+
+// NewManager creates a new Manager.
+func NewManager(opts *ManagerOptions) *Manager {
+	m := &Manager{}
+	if opts != nil {
+		m.opts = *opts
+	}
+	m.packed.manager = m
+	return m
+}
+
+// NewRunner creates a new runner managed by the manager. The options are the same as
+// the NewRunner function, except that OnBulletFired is optional.
+func (m *Manager) NewRunner(bulletML *BulletML, opts *NewRunnerOptions) (Runner, error) {
+	_opts := *opts
+	onBulletFired := opts.OnBulletFired
+	_opts.OnBulletFired = func(b BulletRunner, ctx *FireContext) {
+		if m.deferring {
+			// Parameters are released after this returns, so they are copied
+			p := b.Parent().(*runner)
+			p.deferred = append(p.deferred, deferredFire{
+				runner:       b.(*runner),
+				fireParams:   m.pool.copyParameters(ctx.fireParams),
+				bulletParams: m.pool.copyParameters(ctx.bulletParams),
+			})
+			return
+		}
+
+		// The host must not see bullets which are dropped and reused
+		if m.full() {
+			b.Release()
+			return
+		}
+
+		if onBulletFired != nil {
+			onBulletFired(b, ctx)
+		}
+		m.add(b, ctx)
+	}
+	_opts.perBulletRandom = true
+	_opts.manager = m
+
+	r, err := NewRunner(bulletML, &_opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.runners = append(m.runners, r)
+
+	return r, nil
+}
+
+// full returns whether the manager has MaxBullets bullets.
+func (m *Manager) full() bool {
+	return m.opts.MaxBullets > 0 && m.Len() >= m.opts.MaxBullets
+}
+
+func (m *Manager) add(b BulletRunner, ctx *FireContext) {
+	if b.Vanished() {
+		return
+	}
+
+	if m.full() {
+		b.Release()
+		return
+	}
+
+	var mb *ManagedBullet
+	if n := len(m.free); n > 0 {
+		mb = m.free[n-1]
+		m.free[n-1] = nil
+		m.free = m.free[:n-1]
+	} else {
+		mb = &ManagedBullet{}
+	}
+	mb.Runner = b
+	m.bullets = append(m.bullets, mb)
+	b.(*runner).managed = true
+
+	if m.opts.OnBulletAdded != nil {
+		m.opts.OnBulletAdded(mb, ctx)
+	}
+}
+
+// readd stores the bullets revived by Restore or UnmarshalBinary of r again,
+// which the manager has forgotten after they vanished.
+func (m *Manager) readd(r Runner) {
+	m.compact()
+
+	if b, ok := r.(*runner); ok && b.host == nil && !b.managed {
+		m.add(b, &b.fireContext)
+	}
+	r.WalkDescendants(func(b BulletRunner) bool {
+		if r := b.(*runner); !r.managed {
+			m.add(b, &r.fireContext)
+		}
+		return true
+	})
+}
+
+// Remove stops running the runner created by Manager.NewRunner.
+// Bullets already fired from it are kept.
+func (m *Manager) Remove(r Runner) {
+	for i, _r := range m.runners {
+		if _r == r {
+			m.runners = append(m.runners[:i], m.runners[i+1:]...)
+			return
+		}
+	}
+}
+
+// Update updates all runners and bullets. It should be called in every loop.
+func (m *Manager) Update() error {
+	return m.UpdateDelta(1)
+}
+
+// UpdateDelta is the same as Update but advances dt ticks like Runner.UpdateDelta.
+func (m *Manager) UpdateDelta(dt float64) error {
+	_runners := m.runners[:0]
+	for _, r := range m.runners {
+		if err := r.UpdateDelta(dt); err != nil {
+			return err
+		}
+		if !r.completed() {
+			_runners = append(_runners, r)
+		}
+	}
+	for i := len(_runners); i < len(m.runners); i++ {
+		m.runners[i] = nil
+	}
+	m.runners = _runners
+
+	// Bullets fired by bullets are updated again for the rest of dt after they were fired
+	for lo, hi := 0, len(m.bullets); lo < hi; lo, hi = hi, len(m.bullets) {
+		if m.opts.Workers == 0 {
+			if err := m.updateBullets(dt, lo, hi); err != nil {
+				return err
+			}
+			continue
+		}
+
+		m.deferring = true
+		m.dt, m.base = dt, lo
+		err := m.parallel(hi-lo, (*Manager).updateShard)
+		m.deferring = false
+
+		for _, b := range m.bullets[lo:hi] {
+			r := b.Runner.(*runner)
+			if len(r.children) > 0 {
+				r.compact()
+			}
+			m.deliver(r)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.opts.Workers == 0 {
+		m.packed.update(dt, m.opts.CullRect, 0, len(m.packed.x))
+	} else {
+		m.dt = dt
+		m.parallel(len(m.packed.x), (*Manager).updatePackedShard)
+	}
+
+	m.compact()
+
+	return nil
+}
+
+func (m *Manager) updateBullets(dt float64, lo, hi int) error {
+	for _, b := range m.bullets[lo:hi] {
+		if b.Runner.Vanished() {
+			continue
+		}
+		if err := b.Runner.UpdateDelta(dt); err != nil {
+			return err
+		}
+		if m.opts.CullRect != nil && !m.opts.CullRect.Contains(b.Runner.Position()) {
+			b.Runner.Vanish()
+		}
+	}
+	return nil
+}
+
+// updateShard updates the bullets of the shard [lo, hi) of those from m.base.
+func (m *Manager) updateShard(lo, hi int) error {
+	return m.updateBullets(m.dt, m.base+lo, m.base+hi)
+}
+
+func (m *Manager) updatePackedShard(lo, hi int) error {
+	m.packed.update(m.dt, m.opts.CullRect, lo, hi)
+	return nil
+}
+
+// parallel splits [0, n) into the shards of the workers and calls f for them concurrently.
+// It returns the error of the first shard which fails. f is a method expression instead of
+// a closure so that updates don't allocate.
+func (m *Manager) parallel(n int, f func(m *Manager, lo, hi int) error) error {
+	workers := m.opts.Workers
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		return f(m, 0, n)
+	}
+
+	if cap(m.errs) < workers {
+		m.errs = make([]error, workers)
+	}
+	errs := m.errs[:workers]
+
+	var wg sync.WaitGroup
+	for i := 1; i < workers; i++ {
+		lo, hi := i*n/workers, (i+1)*n/workers
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(m, lo, hi)
+		}(i)
+	}
+	errs[0] = f(m, 0, n/workers)
+	wg.Wait()
+
+	var first error
+	for i, err := range errs {
+		if first == nil {
+			first = err
+		}
+		errs[i] = nil
+	}
+
+	return first
+}
+
+// deliver calls OnBulletFired for the bullets fired by the runner during the parallel update.
+func (m *Manager) deliver(r *runner) {
+	for i, d := range r.deferred {
+		ctx := &d.runner.fireContext
+		ctx.fireParams, ctx.bulletParams = d.fireParams, d.bulletParams
+		r.config.opts.OnBulletFired(d.runner, ctx)
+		ctx.fireParams, ctx.bulletParams = nil, nil
+
+		m.pool.releaseParameters(d.fireParams)
+		m.pool.releaseParameters(d.bulletParams)
+		r.deferred[i] = deferredFire{}
+	}
+	r.deferred = r.deferred[:0]
+}
+
+func (m *Manager) compact() {
+	_bullets := m.bullets[:0]
+	for _, b := range m.bullets {
+		r := b.Runner.(*runner)
+		if r.bullet.vanished {
+			m.removed = append(m.removed, b)
+		} else if m.opts.PackedStorage && len(m.fields) == 0 && canPack(r) {
+			m.packed.add(r, b)
+		} else {
+			_bullets = append(_bullets, b)
+		}
+	}
+	for i := len(_bullets); i < len(m.bullets); i++ {
+		m.bullets[i] = nil
+	}
+	m.bullets = _bullets
+
+	m.removed = m.packed.compact(m.removed)
+
+	for i, b := range m.removed {
+		b.Runner.(*runner).managed = false
+		b.Runner.Release()
+		*b = ManagedBullet{}
+		m.free = append(m.free, b)
+		m.removed[i] = nil
+	}
+	m.removed = m.removed[:0]
+}
+
+// Each calls f for each bullet which has not vanished.
+func (m *Manager) Each(f func(*ManagedBullet)) {
+	for _, b := range m.bullets {
+		if !b.Runner.Vanished() {
+			f(b)
+		}
+	}
+	m.packed.each(f)
+}
+
+// Len returns the number of bullets stored in the manager.
+func (m *Manager) Len() int {
+	return len(m.bullets) + len(m.packed.runners)
+}
+
+// Clear vanishes all bullets in the manager.
+func (m *Manager) Clear() {
+	for _, b := range m.bullets {
+		b.Runner.Vanish()
+	}
+	for _, r := range m.packed.runners {
+		if r != nil {
+			r.Vanish()
+		}
+	}
+	m.compact()
+}
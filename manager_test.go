@@ -0,0 +1,260 @@
+package bulletml
+
+import (
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestManagerMaxBulletsHidesDropped(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <repeat><times>100</times><action>
+    <fire><direction type="sequence">13</direction><speed>1</speed><bullet/></fire>
+    <fire><direction type="sequence">13</direction><speed>1</speed><bullet/></fire>
+    <wait>1</wait>
+  </action></repeat>
+</action>
+</bulletml>`
+
+	var seen []BulletRunner
+	m := NewManager(&ManagerOptions{MaxBullets: 5})
+	_, err := m.NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+		OnBulletFired:         func(b BulletRunner, _ *FireContext) { seen = append(seen, b) },
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := m.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The host sees only the bullets stored in the manager
+	if len(seen) != 5 {
+		t.Errorf("OnBulletFired is called %d times, want 5", len(seen))
+	}
+	for _, b := range seen {
+		if b.(*runner).released {
+			t.Errorf("OnBulletFired is called for a dropped bullet")
+		}
+	}
+}
+
+func TestManagerUpdateAllocs(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <repeat><times>100000</times><action>
+    <fire><direction type="sequence">13</direction><speed>3</speed><bulletRef label="b"><param>0.5</param></bulletRef></fire>
+    <fire><direction type="sequence">-7</direction><speed>2</speed><bullet/></fire>
+    <wait>1</wait>
+  </action></repeat>
+</action>
+<bullet label="b"><action>
+  <wait>10 + $1 * 5</wait>
+  <fire><direction type="relative">$1 * 90</direction><speed>1</speed><bullet/></fire>
+  <changeSpeed><speed>4</speed><term>10</term></changeSpeed>
+</action></bullet>
+</bulletml>`
+
+	bml := loadTestBulletML(t, src)
+
+	for _, workers := range []int{0, 1} {
+		m := NewManager(&ManagerOptions{
+			CullRect: &Rect{MinX: -100, MinY: -100, MaxX: 100, MaxY: 100},
+			Workers:  workers,
+		})
+		_, err := m.NewRunner(bml, &NewRunnerOptions{
+			CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+			CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+			Seed:                  1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Runners and parameters are reused after the number of bullets becomes steady
+		for i := 0; i < 1000; i++ {
+			if err := m.Update(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// A collection, which would empty sync.Pool, must not make the updates allocate.
+		// It runs outside the measurement because it allocates by itself.
+		runtime.GC()
+
+		// The updates run as one so that the count isn't rounded down to 0
+		allocs := testing.AllocsPerRun(1, func() {
+			for i := 0; i < 100; i++ {
+				if err := m.Update(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("Workers %d: %v allocations in 100 updates, want 0", workers, allocs)
+		}
+	}
+}
+
+const managerTestSrc = `<bulletml>
+<action label="top">
+  <repeat><times>50</times><action>
+    <fire><direction type="sequence">$rand * 40</direction><speed>1 + $rand</speed><bulletRef label="split"/></fire>
+    <fire><direction type="aim">0</direction><speed>2</speed><bullet><action>
+      <accel><horizontal>0.05</horizontal><term>20</term></accel>
+    </action></bullet></fire>
+    <wait>3</wait>
+  </action></repeat>
+</action>
+<bullet label="split"><action>
+  <changeSpeed><speed>3</speed><term>15</term></changeSpeed>
+  <wait>10 + $rand * 10</wait>
+  <repeat><times>3</times><action>
+    <fire damage="$rand * 10"><direction type="sequence">120</direction><speed>1.5</speed><bullet/></fire>
+  </action></repeat>
+  <vanish/>
+</action></bullet>
+</bulletml>`
+
+// managerPositions runs the test pattern in a manager and returns the sorted positions
+// of the bullets with the values of their 'damage' attributes.
+func managerPositions(t *testing.T, opts ManagerOptions, ticks int) [][3]float64 {
+	t.Helper()
+	opts.OnBulletAdded = func(b *ManagedBullet, ctx *FireContext) {
+		damage, err := ctx.FloatAttr("damage", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Data = damage
+	}
+	m := NewManager(&opts)
+	_, err := m.NewRunner(loadTestBulletML(t, managerTestSrc), &NewRunnerOptions{
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 30, 200 },
+		Seed:                  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < ticks; i++ {
+		if err := m.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var positions [][3]float64
+	m.Each(func(b *ManagedBullet) {
+		x, y := b.Runner.Position()
+		positions = append(positions, [3]float64{x, y, b.Data.(float64)})
+	})
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i][0] != positions[j][0] {
+			return positions[i][0] < positions[j][0]
+		}
+		return positions[i][1] < positions[j][1]
+	})
+	return positions
+}
+
+func TestManagerStorageAndWorkers(t *testing.T) {
+	cull := &Rect{MinX: -300, MinY: -300, MaxX: 300, MaxY: 300}
+	want := managerPositions(t, ManagerOptions{CullRect: cull}, 120)
+	if len(want) == 0 {
+		t.Fatal("no bullets")
+	}
+
+	tests := []struct {
+		name string
+		opts ManagerOptions
+	}{
+		{"packed", ManagerOptions{CullRect: cull, PackedStorage: true}},
+		{"1 worker", ManagerOptions{CullRect: cull, Workers: 1}},
+		{"4 workers", ManagerOptions{CullRect: cull, Workers: 4}},
+		{"4 workers packed", ManagerOptions{CullRect: cull, Workers: 4, PackedStorage: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := managerPositions(t, tt.opts, 120)
+			if len(got) != len(want) {
+				t.Fatalf("%d bullets, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("bullet at %v, want %v", got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestManagerCaps(t *testing.T) {
+	// The shooter fires a bullet every other tick, which fires another bullet two ticks later,
+	// so 9 bullets are fired in 10 ticks. They move 10 per tick to the right,
+	// and only the newest one is in the cull rect.
+	const src = `<bulletml>
+<action label="top">
+  <repeat><times>100</times><action>
+    <fire><direction type="absolute">90</direction><speed>10</speed>
+      <bullet><action><wait>1</wait><fire><direction type="relative">0</direction><speed>10</speed><bullet/></fire></action></bullet>
+    </fire>
+    <wait>1</wait>
+  </action></repeat>
+</action>
+</bulletml>`
+
+	cull := &Rect{MinX: -25, MinY: -25, MaxX: 25, MaxY: 25}
+	tests := []struct {
+		name string
+		opts ManagerOptions
+
+		// len is the number of the stored bullets after the updates,
+		// and added is the number of the calls of OnBulletAdded
+		len, added int
+	}{
+		{"no limit", ManagerOptions{}, 9, 9},
+		{"max bullets", ManagerOptions{MaxBullets: 5}, 5, 5},
+		{"max bullets with workers", ManagerOptions{MaxBullets: 5, Workers: 4}, 5, 5},
+		{"max bullets not reached", ManagerOptions{MaxBullets: 100}, 9, 9},
+		{"cull rect", ManagerOptions{CullRect: cull}, 1, 9},
+		{"cull rect frees room", ManagerOptions{CullRect: cull, MaxBullets: 1}, 1, 3},
+		{"cull rect frees room with workers", ManagerOptions{CullRect: cull, MaxBullets: 1, Workers: 4}, 1, 3},
+		{"cull rect frees room packed", ManagerOptions{CullRect: cull, MaxBullets: 1, PackedStorage: true}, 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added := 0
+			opts := tt.opts
+			opts.OnBulletAdded = func(*ManagedBullet, *FireContext) { added++ }
+			m := NewManager(&opts)
+			_, err := m.NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := m.Update(); err != nil {
+					t.Fatal(err)
+				}
+				if max := opts.MaxBullets; max > 0 && m.Len() > max {
+					t.Fatalf("%d bullets are stored at tick %d, want at most %d", m.Len(), i, max)
+				}
+			}
+
+			if m.Len() != tt.len {
+				t.Errorf("%d bullets are stored, want %d", m.Len(), tt.len)
+			}
+			if added != tt.added {
+				t.Errorf("OnBulletAdded is called %d times, want %d", added, tt.added)
+			}
+		})
+	}
+}
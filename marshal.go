@@ -0,0 +1,924 @@
+package bulletml
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	binaryMagic   = "BMLR"
+	binaryVersion = 1
+)
+
+const (
+	binaryKindMulti byte = iota
+	binaryKindBullet
+)
+
+// MarshalBinary encodes the state of the runner and the bullets fired from it.
+// The data can be loaded by UnmarshalBinary of a runner created from the same BulletML document.
+func (m *multiRunner) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter(m.config, binaryKindMulti)
+
+	w.uvarint(uint64(len(m.runners)))
+	for _, r := range m.runners {
+		w.runner(r)
+	}
+
+	// The bullets fired by the runners are saved with the index of the runner
+	// which fired them, or -1 if it has completed
+	w.uvarint(uint64(len(m.children)))
+	for _, c := range m.children {
+		index := -1
+		for i, r := range m.runners {
+			if c.fireContext.runner == r {
+				index = i
+			}
+		}
+		w.varint(index)
+		w.runner(c)
+	}
+
+	return w.buf, w.err
+}
+
+// UnmarshalBinary restores the state encoded by MarshalBinary.
+// Bullets fired before loading are vanished, so the host should rebuild its bullet list with WalkDescendants.
+// Runners created by Manager store the loaded bullets in the manager instead.
+func (m *multiRunner) UnmarshalBinary(data []byte) error {
+	r, err := newBinaryReader(data, m.config, binaryKindMulti)
+	if err != nil {
+		return err
+	}
+
+	// The shooter shared by the runners is loaded into a temporary model
+	// so that it is kept on failure
+	var shooter *bulletModel
+	if m.shooter != nil {
+		shooter = &bulletModel{}
+	}
+
+	var runners []*runner
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		b := shooter
+		if b == nil {
+			b = &bulletModel{}
+		}
+		rn := createRunner(m.config, b)
+		rn.host = m
+		r.runner(rn, m.config.bulletConfig)
+		runners = append(runners, rn)
+	}
+	// FireContext evaluates expressions with the runner which fired the bullet,
+	// which is replaced with an idle runner if it has completed
+	completed := createRunner(m.config, &bulletModel{})
+	completed.host = m
+
+	var f family
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		c := createRunner(m.config.bulletConfig, &bulletModel{})
+		c.parent = m
+		switch index := r.varint(); {
+		case index >= 0 && index < len(runners):
+			c.fireContext.runner = runners[index]
+		case index == -1:
+			c.fireContext.runner = completed
+		default:
+			r.invalid("runner index")
+		}
+
+		r.runner(c, m.config.bulletConfig)
+		f.children = append(f.children, c)
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+
+	m.family.each(func(r *runner) {
+		r.markVanished()
+	})
+	if shooter != nil && len(runners) > 0 {
+		*m.shooter = *shooter
+		for _, r := range runners {
+			r.bullet = m.shooter
+		}
+	}
+	m.runners = runners
+	m.children = f.children
+	r.restoreRandom()
+	if mgr := m.config.opts.manager; mgr != nil {
+		mgr.readd(m)
+	}
+
+	return nil
+}
+
+// MarshalBinary encodes the state of the runner and the bullets fired from it.
+func (r *runner) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter(r.config, binaryKindBullet)
+	w.runner(r)
+
+	return w.buf, w.err
+}
+
+// UnmarshalBinary restores the state encoded by MarshalBinary.
+func (r *runner) UnmarshalBinary(data []byte) error {
+	br, err := newBinaryReader(data, r.config, binaryKindBullet)
+	if err != nil {
+		return err
+	}
+
+	loaded := createRunner(r.config, &bulletModel{})
+	br.runner(loaded, r.config)
+	if br.err != nil {
+		return br.err
+	}
+
+	r.family.each(func(r *runner) {
+		r.markVanished()
+	})
+
+	r.unpack()
+
+	bullet := r.bullet
+	*bullet = *loaded.bullet
+	loaded.bullet = bullet
+	loaded.host, loaded.parent = r.host, r.parent
+	loaded.fireContext, loaded.released, loaded.generation = r.fireContext, r.released, r.generation
+	loaded.managed, loaded.pins = r.managed, r.pins
+	for _, p := range loaded.stack {
+		p.runner = r
+	}
+	for _, c := range loaded.children {
+		c.parent, c.fireContext.runner = r, r
+	}
+	*r = *loaded
+	br.restoreRandom()
+	if m := r.config.opts.manager; m != nil {
+		m.readd(r)
+	}
+
+	return nil
+}
+
+// documentIndex identifies nodes of a BulletML document by stable IDs.
+type documentIndex struct {
+	once        sync.Once
+	fingerprint uint64
+	actions     []*Action
+	actionIDs   map[*Action]int
+	fires       []*Fire
+	fireIDs     map[*Fire]int
+	bullets     []*Bullet
+	bulletIDs   map[*Bullet]int
+}
+
+func (c *runnerConfig) documentIndex() *documentIndex {
+	c.index.once.Do(func() {
+		c.index.build(c.bulletML)
+	})
+	return c.index
+}
+
+func (idx *documentIndex) build(bulletML *BulletML) {
+	idx.actionIDs = make(map[*Action]int)
+	idx.fireIDs = make(map[*Fire]int)
+	idx.bulletIDs = make(map[*Bullet]int)
+	h := fnv.New64a()
+	eachNode(bulletML, func(n node) {
+		fmt.Fprintf(h, "%s;", nodeSignature(n))
+		switch n := n.(type) {
+		case *Action:
+			idx.actionIDs[n] = len(idx.actions)
+			idx.actions = append(idx.actions, n)
+		case *Fire:
+			idx.fireIDs[n] = len(idx.fires)
+			idx.fires = append(idx.fires, n)
+		case *Bullet:
+			idx.bulletIDs[n] = len(idx.bullets)
+			idx.bullets = append(idx.bullets, n)
+		}
+	})
+	idx.fingerprint = h.Sum64()
+}
+
+func eachNode(n node, fn func(node)) {
+	fn(n)
+
+	switch n := n.(type) {
+	case *BulletML:
+		for _, b := range n.Bullets {
+			eachNode(b, fn)
+		}
+		for _, a := range n.Actions {
+			eachNode(a, fn)
+		}
+		for _, f := range n.Fires {
+			eachNode(f, fn)
+		}
+	case *Bullet:
+		if d, exists := n.Direction.Get(); exists {
+			eachNode(d, fn)
+		}
+		if s, exists := n.Speed.Get(); exists {
+			eachNode(s, fn)
+		}
+		for _, a := range n.ActionOrRefs {
+			eachNode(a.(node), fn)
+		}
+	case *Action:
+		for _, c := range n.Commands {
+			eachNode(c.(node), fn)
+		}
+	case *Fire:
+		if d, exists := n.Direction.Get(); exists {
+			eachNode(d, fn)
+		}
+		if s, exists := n.Speed.Get(); exists {
+			eachNode(s, fn)
+		}
+		if b, exists := n.Bullet.Get(); exists {
+			eachNode(b, fn)
+		}
+		if b, exists := n.BulletRef.Get(); exists {
+			eachNode(b, fn)
+		}
+		if o, exists := n.Offset.Get(); exists {
+			eachNode(o, fn)
+		}
+	case *Offset:
+		if x, exists := n.X.Get(); exists {
+			eachNode(x, fn)
+		}
+		if y, exists := n.Y.Get(); exists {
+			eachNode(y, fn)
+		}
+	case *ChangeDirection:
+		eachNode(n.Direction, fn)
+		eachNode(n.Term, fn)
+	case *ChangeSpeed:
+		eachNode(n.Speed, fn)
+		eachNode(n.Term, fn)
+	case *Accel:
+		if h, exists := n.Horizontal.Get(); exists {
+			eachNode(h, fn)
+		}
+		if v, exists := n.Vertical.Get(); exists {
+			eachNode(v, fn)
+		}
+		if t, exists := n.Tangential.Get(); exists {
+			eachNode(t, fn)
+		}
+		if nm, exists := n.Normal.Get(); exists {
+			eachNode(nm, fn)
+		}
+		eachNode(n.Term, fn)
+	case *Homing:
+		eachNode(n.Term, fn)
+		eachNode(n.TurnRate, fn)
+		if a, exists := n.Acceleration.Get(); exists {
+			eachNode(a, fn)
+		}
+	case *Repeat:
+		eachNode(n.Times, fn)
+		if a, exists := n.Action.Get(); exists {
+			eachNode(a, fn)
+		}
+		if a, exists := n.ActionRef.Get(); exists {
+			eachNode(a, fn)
+		}
+	case *BulletRef:
+		for _, p := range n.Params {
+			eachNode(p, fn)
+		}
+	case *ActionRef:
+		for _, p := range n.Params {
+			eachNode(p, fn)
+		}
+	case *FireRef:
+		for _, p := range n.Params {
+			eachNode(p, fn)
+		}
+	}
+}
+
+func nodeSignature(n node) string {
+	switch n := n.(type) {
+	case *BulletML:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Type)
+	case *Bullet:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Label)
+	case *Action:
+		if n.Emitter != "" {
+			return fmt.Sprintf("%s|%s|%d|%s", n.xmlName(), n.Label, len(n.Commands), n.Emitter)
+		}
+		return fmt.Sprintf("%s|%s|%d", n.xmlName(), n.Label, len(n.Commands))
+	case *Fire:
+		if n.Emitter != "" {
+			return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Label, n.Emitter)
+		}
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Label)
+	case *Wait:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *Direction:
+		if n.Target != "" {
+			return fmt.Sprintf("%s|%s|%s|%s", n.xmlName(), n.Type, n.Expr, n.Target)
+		}
+		return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Type, n.Expr)
+	case *Speed:
+		return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Type, n.Expr)
+	case *Horizontal:
+		return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Type, n.Expr)
+	case *Vertical:
+		return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Type, n.Expr)
+	case *Tangential:
+		return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Type, n.Expr)
+	case *Normal:
+		return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Type, n.Expr)
+	case *Offset:
+		return fmt.Sprintf("%s|%s|%t", n.xmlName(), n.Type, n.Reaim)
+	case *ChangeSpeed:
+		if n.Ease != "" {
+			return fmt.Sprintf("%s|%s", n.xmlName(), n.Ease)
+		}
+		return n.xmlName()
+	case *ChangeDirection:
+		if n.Rotate != "" && n.Rotate != RotateTypeShortest {
+			return fmt.Sprintf("%s|%s|%s", n.xmlName(), n.Ease, n.Rotate)
+		}
+		if n.Ease != "" {
+			return fmt.Sprintf("%s|%s", n.xmlName(), n.Ease)
+		}
+		return n.xmlName()
+	case *Accel:
+		if n.Ease != "" {
+			return fmt.Sprintf("%s|%s", n.xmlName(), n.Ease)
+		}
+		return n.xmlName()
+	case *OffsetX:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *OffsetY:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *Term:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *TurnRate:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *Acceleration:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *Times:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case *Param:
+		return fmt.Sprintf("%s|%s", n.xmlName(), n.Expr)
+	case refType:
+		return fmt.Sprintf("%s|%s|%d", n.xmlName(), n.label(), len(n.params()))
+	case *CustomCommand:
+		return fmt.Sprintf("%s|%v|%s", n.xmlName(), n.Attrs, n.InnerXML)
+	default:
+		return n.xmlName()
+	}
+}
+
+type binaryWriter struct {
+	buf   []byte
+	index *documentIndex
+	err   error
+}
+
+func newBinaryWriter(config *runnerConfig, kind byte) *binaryWriter {
+	w := &binaryWriter{index: config.documentIndex()}
+
+	w.buf = append(w.buf, binaryMagic...)
+	w.uvarint(binaryVersion)
+	w.fixed64(w.index.fingerprint)
+	w.buf = append(w.buf, kind)
+
+	if config.randomSource != nil {
+		w.bool(true)
+		w.uvarint(config.randomSource.state)
+	} else {
+		w.bool(false)
+	}
+
+	return w
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *binaryWriter) varint(v int) {
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *binaryWriter) fixed64(v uint64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, v)
+}
+
+func (w *binaryWriter) float(v float64) {
+	w.fixed64(math.Float64bits(v))
+}
+
+func (w *binaryWriter) bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *binaryWriter) string(v string) {
+	w.uvarint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *binaryWriter) action(a *Action) {
+	if a == nil {
+		w.uvarint(0)
+		return
+	}
+
+	id, exists := w.index.actionIDs[a]
+	if !exists {
+		w.err = newBulletmlError("Action not found in the document", a)
+		return
+	}
+	w.uvarint(uint64(id) + 1)
+}
+
+func (w *binaryWriter) fire(f *Fire) {
+	if f == nil {
+		w.uvarint(0)
+		return
+	}
+
+	id, exists := w.index.fireIDs[f]
+	if !exists {
+		w.err = newBulletmlError("Fire not found in the document", f)
+		return
+	}
+	w.uvarint(uint64(id) + 1)
+}
+
+func (w *binaryWriter) bullet(b *Bullet) {
+	if b == nil {
+		w.uvarint(0)
+		return
+	}
+
+	id, exists := w.index.bulletIDs[b]
+	if !exists {
+		w.err = newBulletmlError("Bullet not found in the document", b)
+		return
+	}
+	w.uvarint(uint64(id) + 1)
+}
+
+func (w *binaryWriter) easing(e *easing) {
+	w.string(e.name)
+	if e.name == "" {
+		return
+	}
+	w.varint(e.startTick)
+	w.float(e.term)
+	for i := range e.from {
+		w.float(e.from[i])
+		w.float(e.span[i])
+	}
+}
+
+func (w *binaryWriter) params(params parameters) {
+	if params == nil {
+		w.bool(false)
+		return
+	}
+	w.bool(true)
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		w.string(k)
+		w.float(params[k])
+	}
+}
+
+func (w *binaryWriter) runner(r *runner) {
+	r.sync()
+
+	b := r.bullet
+	w.float(b.x)
+	w.float(b.y)
+	w.float(b.speed)
+	w.float(b.direction)
+	w.float(b.accelSpeedHorizontal)
+	w.float(b.accelSpeedVertical)
+	w.float(b.accelSpeedTangential)
+	w.float(b.accelSpeedNormal)
+	w.float(b.fieldVx)
+	w.float(b.fieldVy)
+	w.bool(b.vanished)
+
+	w.float(r.bulletVxCache)
+	w.float(r.bulletVyCache)
+	w.varint(r.ticks)
+	w.float(r.tickProgress)
+	w.float(r.spawnDelay)
+	w.varint(r.waitUntil)
+	w.varint(r.changeSpeedUntil)
+	w.float(r.changeSpeedDelta)
+	w.float(r.changeSpeedTarget)
+	w.varint(r.changeDirectionUntil)
+	w.float(r.changeDirectionDelta)
+	w.float(r.changeDirectionTarget)
+	w.varint(r.accelUntil)
+	w.float(r.accelHorizontalDelta)
+	w.float(r.accelHorizontalTarget)
+	w.float(r.accelVerticalDelta)
+	w.float(r.accelVerticalTarget)
+	w.float(r.accelTangentialDelta)
+	w.float(r.accelTangentialTarget)
+	w.float(r.accelNormalDelta)
+	w.float(r.accelNormalTarget)
+	w.varint(r.homingUntil)
+	w.float(r.homingTurnRate)
+	w.float(r.homingAcceleration)
+	w.float(r.homingFraction)
+	w.easing(&r.changeSpeedEasing)
+	w.easing(&r.changeDirectionEasing)
+	w.easing(&r.accelEasing)
+	w.float(r.lastFireDirection)
+	w.float(r.lastFireSpeed)
+	w.float(r.waitCarry)
+	w.uvarint(r.random.state)
+	w.varint(r.target)
+	w.varint(r.targetTurn)
+	w.bool(r.allActionsCompleted)
+	w.fire(r.fireContext.Fire)
+	w.bullet(r.fireContext.Bullet)
+	w.string(r.fireContext.Emitter)
+
+	w.uvarint(uint64(len(r.stack)))
+	for _, p := range r.stack {
+		w.action(p.action)
+		w.varint(p.actionIndex)
+		w.varint(p.repeatIndex)
+		w.varint(p.repeatCount)
+		w.action(p.repeatActionCache)
+		w.params(p.repeatParamsCache)
+		w.params(p.params)
+	}
+
+	w.children(&r.family)
+}
+
+func (w *binaryWriter) children(f *family) {
+	w.uvarint(uint64(len(f.children)))
+	for _, c := range f.children {
+		w.runner(c)
+	}
+}
+
+type binaryReader struct {
+	data      []byte
+	index     *documentIndex
+	config    *runnerConfig
+	random    uint64
+	hasRandom bool
+	err       error
+}
+
+func newBinaryReader(data []byte, config *runnerConfig, kind byte) (*binaryReader, error) {
+	r := &binaryReader{data: data, index: config.documentIndex(), config: config}
+
+	if len(r.data) < len(binaryMagic) || string(r.data[:len(binaryMagic)]) != binaryMagic {
+		return nil, errors.New("Invalid data format")
+	}
+	r.data = r.data[len(binaryMagic):]
+
+	if v := r.uvarint(); r.err == nil && v != binaryVersion {
+		return nil, fmt.Errorf("Unsupported data version: %d", v)
+	}
+
+	if r.fixed64() != r.index.fingerprint && r.err == nil {
+		return nil, errors.New("BulletML document has changed since the data was saved")
+	}
+	if k := r.byte(); k != kind && r.err == nil {
+		return nil, errors.New("Data was saved from another kind of runner")
+	}
+
+	r.hasRandom = r.bool()
+	if r.hasRandom {
+		r.random = r.uvarint()
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return r, nil
+}
+
+func (r *binaryReader) restoreRandom() {
+	if r.hasRandom && r.config.randomSource != nil {
+		r.config.randomSource.state = r.random
+	}
+}
+
+func (r *binaryReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("Unexpected end of data")
+	}
+	r.data = nil
+}
+
+// invalid stops reading at a value which the runner can't be in.
+func (r *binaryReader) invalid(what string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("Invalid %s", what)
+	}
+	r.data = nil
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return int(v)
+}
+
+func (r *binaryReader) fixed64() uint64 {
+	if len(r.data) < 8 {
+		r.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *binaryReader) float() float64 {
+	return math.Float64frombits(r.fixed64())
+}
+
+func (r *binaryReader) byte() byte {
+	if len(r.data) < 1 {
+		r.fail()
+		return 0
+	}
+	v := r.data[0]
+	r.data = r.data[1:]
+	return v
+}
+
+func (r *binaryReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *binaryReader) string() string {
+	n := r.uvarint()
+	if uint64(len(r.data)) < n {
+		r.fail()
+		return ""
+	}
+	v := string(r.data[:n])
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) action() *Action {
+	id := r.uvarint()
+	if id == 0 {
+		return nil
+	}
+	if id > uint64(len(r.index.actions)) {
+		if r.err == nil {
+			r.err = fmt.Errorf("Invalid action ID: %d", id-1)
+		}
+		r.data = nil
+		return nil
+	}
+	return r.index.actions[id-1]
+}
+
+func (r *binaryReader) fire() *Fire {
+	id := r.uvarint()
+	if id == 0 {
+		return nil
+	}
+	if id > uint64(len(r.index.fires)) {
+		if r.err == nil {
+			r.err = fmt.Errorf("Invalid fire ID: %d", id-1)
+		}
+		r.data = nil
+		return nil
+	}
+	return r.index.fires[id-1]
+}
+
+func (r *binaryReader) bullet() *Bullet {
+	id := r.uvarint()
+	if id == 0 {
+		return nil
+	}
+	if id > uint64(len(r.index.bullets)) {
+		if r.err == nil {
+			r.err = fmt.Errorf("Invalid bullet ID: %d", id-1)
+		}
+		r.data = nil
+		return nil
+	}
+	return r.index.bullets[id-1]
+}
+
+func (r *binaryReader) easing(e *easing) {
+	*e = easing{name: r.string()}
+	if e.name == "" || r.err != nil {
+		return
+	}
+	fn, exists := lookUpEasing(e.name)
+	if !exists {
+		r.err = fmt.Errorf("Unknown easing: %s", e.name)
+		return
+	}
+	e.fn = fn
+	e.startTick = r.varint()
+	e.term = r.float()
+	if !(e.term > 0) {
+		r.invalid("easing term")
+	}
+	for i := range e.from {
+		e.from[i] = r.float()
+		e.span[i] = r.float()
+	}
+}
+
+func (r *binaryReader) params() parameters {
+	if !r.bool() {
+		return nil
+	}
+
+	n := r.uvarint()
+	params := make(parameters)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		k := r.string()
+		params[k] = r.float()
+	}
+	return params
+}
+
+func (r *binaryReader) runner(rn *runner, bulletConfig *runnerConfig) {
+	b := rn.bullet
+	b.x = r.float()
+	b.y = r.float()
+	b.speed = r.float()
+	b.direction = r.float()
+	b.accelSpeedHorizontal = r.float()
+	b.accelSpeedVertical = r.float()
+	b.accelSpeedTangential = r.float()
+	b.accelSpeedNormal = r.float()
+	b.fieldVx = r.float()
+	b.fieldVy = r.float()
+	b.vanished = r.bool()
+
+	rn.bulletVxCache = r.float()
+	rn.bulletVyCache = r.float()
+	rn.ticks = r.varint()
+	rn.tickProgress = r.float()
+	rn.spawnDelay = r.float()
+	rn.waitUntil = r.varint()
+	rn.changeSpeedUntil = r.varint()
+	rn.changeSpeedDelta = r.float()
+	rn.changeSpeedTarget = r.float()
+	rn.changeDirectionUntil = r.varint()
+	rn.changeDirectionDelta = r.float()
+	rn.changeDirectionTarget = r.float()
+	rn.accelUntil = r.varint()
+	rn.accelHorizontalDelta = r.float()
+	rn.accelHorizontalTarget = r.float()
+	rn.accelVerticalDelta = r.float()
+	rn.accelVerticalTarget = r.float()
+	rn.accelTangentialDelta = r.float()
+	rn.accelTangentialTarget = r.float()
+	rn.accelNormalDelta = r.float()
+	rn.accelNormalTarget = r.float()
+	rn.homingUntil = r.varint()
+	rn.homingTurnRate = r.float()
+	rn.homingAcceleration = r.float()
+	rn.homingFraction = r.float()
+	r.easing(&rn.changeSpeedEasing)
+	r.easing(&rn.changeDirectionEasing)
+	r.easing(&rn.accelEasing)
+	rn.lastFireDirection = r.float()
+	rn.lastFireSpeed = r.float()
+	rn.waitCarry = r.float()
+	rn.random.state = r.uvarint()
+	rn.target = r.varint()
+	rn.targetTurn = r.varint()
+	rn.allActionsCompleted = r.bool()
+	rn.fireContext.Fire = r.fire()
+	rn.fireContext.Bullet = r.bullet()
+	rn.fireContext.Emitter = r.string()
+	if rn.config.opts.perBulletRandom && rn.host == nil {
+		rn.fireContext.random = &rn.random
+	}
+
+	// Values out of these ranges would make the runner panic or loop endlessly
+	switch {
+	case !(rn.tickProgress >= 0 && rn.tickProgress < 1):
+		r.invalid("tick progress")
+	case !(rn.spawnDelay >= 0) || math.IsInf(rn.spawnDelay, 1):
+		r.invalid("spawn delay")
+	case !(rn.waitCarry >= 0 && rn.waitCarry < 1):
+		r.invalid("wait carry")
+	case rn.target < 0 || rn.targetTurn < 0:
+		r.invalid("target")
+	}
+
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		p := &actionProcess{runner: rn}
+		p.action = r.action()
+		p.actionIndex = r.varint()
+		p.repeatIndex = r.varint()
+		p.repeatCount = r.varint()
+		p.repeatActionCache = r.action()
+		p.repeatParamsCache = r.params()
+		p.params = r.params()
+		if r.err == nil && !r.validProcess(p) {
+			r.invalid("action process")
+		}
+		rn.stack = append(rn.stack, p)
+	}
+
+	r.children(&rn.family, rn, bulletConfig)
+}
+
+// validProcess returns whether the process points at a command of its action
+// and its repeat state belongs to the <repeat> there.
+func (r *binaryReader) validProcess(p *actionProcess) bool {
+	if p.action == nil || p.actionIndex < 0 || p.actionIndex > len(p.action.Commands) {
+		return false
+	}
+	if p.repeatIndex < 0 || p.repeatCount < 0 || p.repeatIndex > p.repeatCount {
+		return false
+	}
+	if p.repeatIndex == 0 && p.repeatActionCache == nil {
+		return true
+	}
+
+	var c *Repeat
+	if p.actionIndex < len(p.action.Commands) {
+		c, _ = p.action.Commands[p.actionIndex].(*Repeat)
+	}
+	if c == nil {
+		return false
+	}
+	if p.repeatActionCache != nil {
+		if p.repeatParamsCache == nil {
+			return false
+		}
+		if a, exists := c.Action.Get(); exists {
+			return p.repeatActionCache == a
+		}
+		if ref, exists := c.ActionRef.Get(); exists {
+			return p.repeatActionCache == r.config.actionDefTable[ref.label()]
+		}
+		return false
+	}
+	return true
+}
+
+func (r *binaryReader) children(f *family, parent *runner, bulletConfig *runnerConfig) {
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		c := createRunner(bulletConfig, &bulletModel{})
+		c.parent, c.fireContext.runner = parent, parent
+		r.runner(c, bulletConfig)
+		f.children = append(f.children, c)
+	}
+}
@@ -0,0 +1,166 @@
+package bulletml
+
+import (
+	"encoding"
+	"testing"
+)
+
+const marshalTestSrc = `<bulletml>
+<action label="top">
+  <repeat><times>100</times><action>
+    <fire><direction type="aim">$rand * 20 - 10</direction><speed>2</speed><bulletRef label="turn"/></fire>
+    <repeat><times>3</times><actionRef label="spread"><param>$rank * 10</param></actionRef></repeat>
+    <wait>2.5</wait>
+  </action></repeat>
+</action>
+<action label="spread">
+  <fire><direction type="sequence">$1</direction><speed>1</speed><bullet/></fire>
+  <wait>1</wait>
+</action>
+<bullet label="turn"><action>
+  <changeDirection ease="inOutSine"><direction type="aim">0</direction><term>10</term></changeDirection>
+  <accel><horizontal>0.1</horizontal><term>8</term></accel>
+  <wait>4</wait>
+  <fire><direction type="relative">0</direction><bullet/></fire>
+</action></bullet>
+</bulletml>`
+
+func newMarshalTestRunner(t testing.TB, bml *BulletML) Runner {
+	r, err := NewRunner(bml, &NewRunnerOptions{
+		OnBulletFired:         func(BulletRunner, *FireContext) {},
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+		Targets:               func() []Target { return []Target{{X: -50, Y: 100}, {X: 50, Y: 100}} },
+		Rank:                  0.5,
+		Seed:                  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// updateAll updates the runner and the bullets fired from it.
+func updateAll(r Runner) error {
+	if err := r.Update(); err != nil {
+		return err
+	}
+	var bullets []BulletRunner
+	r.WalkDescendants(func(b BulletRunner) bool {
+		bullets = append(bullets, b)
+		return true
+	})
+	for _, b := range bullets {
+		if err := b.Update(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	bml := loadTestBulletML(f, marshalTestSrc)
+
+	r := newMarshalTestRunner(f, bml)
+	for i := 0; i < 60; i++ {
+		if err := updateAll(r); err != nil {
+			f.Fatal(err)
+		}
+		if i%20 == 3 {
+			data, err := r.(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := newMarshalTestRunner(t, bml)
+		if err := r.(encoding.BinaryUnmarshaler).UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// The loaded state must run without panics or endless loops
+		for i := 0; i < 30; i++ {
+			if err := updateAll(r); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestUnmarshalBinaryFireContext(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <fire damage="$rank * 2"><speed>1</speed><bullet><action>
+    <fire damage="$rank * 3"><speed>1</speed><bullet/></fire>
+    <wait>100</wait>
+  </action></bullet></fire>
+  <wait>100</wait>
+</action>
+</bulletml>`
+
+	bml := loadTestBulletML(t, src)
+
+	for _, kind := range []string{"multi", "bullet"} {
+		t.Run(kind, func(t *testing.T) {
+			var damages []float64
+			m := NewManager(&ManagerOptions{
+				OnBulletAdded: func(_ *ManagedBullet, ctx *FireContext) {
+					v, err := ctx.FloatAttr("damage", 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+					damages = append(damages, v)
+				},
+			})
+			r, err := m.NewRunner(bml, &NewRunnerOptions{
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+				Rank:                  0.5,
+				Seed:                  1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if err := m.Update(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var target any = r
+			if kind == "bullet" {
+				m.Each(func(b *ManagedBullet) {
+					if b.Runner.Parent() == r {
+						target = b.Runner
+					}
+				})
+			}
+			data, err := target.(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			damages = nil
+			if err := target.(encoding.BinaryUnmarshaler).UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+
+			// The bullets revived by loading are stored again with their contexts
+			want := []float64{1, 1.5}
+			if kind == "bullet" {
+				want = want[1:]
+			}
+			if len(damages) != len(want) {
+				t.Fatalf("damages %v, want %v", damages, want)
+			}
+			for i := range want {
+				if damages[i] != want[i] {
+					t.Errorf("damages %v, want %v", damages, want)
+				}
+			}
+		})
+	}
+}
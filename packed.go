@@ -0,0 +1,143 @@
+package bulletml
+
+// packedBullets stores bullets which have finished all actions in contiguous slices.
+// Such bullets only move straight, so they are updated without touching their runners.
+// The runners remain as views of the bullets and read positions from here.
+type packedBullets struct {
+	manager  *Manager
+	x, y     []float64
+	vx, vy   []float64
+	progress []float64
+	vanished []bool
+	runners  []*runner
+	bullets  []*ManagedBullet
+}
+
+// canPack returns whether the bullet only moves straight from now on.
+func canPack(r *runner) bool {
+	return r.packed == nil && r.host == nil && !r.bullet.vanished && r.completed()
+}
+
+func (s *packedBullets) add(r *runner, b *ManagedBullet) {
+	vx, vy := r.velocity()
+
+	r.packed, r.packedIndex = s, len(s.runners)
+
+	s.x = append(s.x, r.bullet.x)
+	s.y = append(s.y, r.bullet.y)
+	s.vx = append(s.vx, vx)
+	s.vy = append(s.vy, vy)
+	s.progress = append(s.progress, r.tickProgress)
+	s.vanished = append(s.vanished, false)
+	s.runners = append(s.runners, r)
+	s.bullets = append(s.bullets, b)
+}
+
+// update moves the bullets in [lo, hi) in the same steps as runner.UpdateDelta does,
+// so that the results are identical to the ones of unpacked bullets.
+func (s *packedBullets) update(dt float64, cullRect *Rect, lo, hi int) {
+	for i := lo; i < hi; i++ {
+		if s.vanished[i] {
+			continue
+		}
+
+		x, y, vx, vy, progress := s.x[i], s.y[i], s.vx[i], s.vy[i], s.progress[i]
+		for d := dt; d > tickEpsilon; {
+			step := 1 - progress
+			if d < step-tickEpsilon {
+				step = d
+			}
+
+			x += float64(vx * step)
+			y += float64(vy * step)
+
+			progress += step
+			d -= step
+
+			if progress >= 1-tickEpsilon {
+				progress = 0
+			}
+		}
+		s.x[i], s.y[i], s.progress[i] = x, y, progress
+
+		if cullRect != nil && !cullRect.Contains(x, y) {
+			s.runners[i].Vanish()
+		}
+	}
+}
+
+// compact removes vanished bullets and returns them.
+func (s *packedBullets) compact(removed []*ManagedBullet) []*ManagedBullet {
+	j := 0
+	for i := range s.runners {
+		if s.vanished[i] {
+			if r := s.runners[i]; r != nil {
+				r.packed = nil
+				removed = append(removed, s.bullets[i])
+			}
+			continue
+		}
+
+		if i != j {
+			s.x[j], s.y[j] = s.x[i], s.y[i]
+			s.vx[j], s.vy[j] = s.vx[i], s.vy[i]
+			s.progress[j] = s.progress[i]
+			s.vanished[j] = false
+			s.runners[j], s.bullets[j] = s.runners[i], s.bullets[i]
+			s.runners[j].packedIndex = j
+		}
+		j++
+	}
+
+	for i := j; i < len(s.runners); i++ {
+		s.runners[i], s.bullets[i] = nil, nil
+	}
+	s.x, s.y = s.x[:j], s.y[:j]
+	s.vx, s.vy = s.vx[:j], s.vy[:j]
+	s.progress = s.progress[:j]
+	s.vanished = s.vanished[:j]
+	s.runners, s.bullets = s.runners[:j], s.bullets[:j]
+
+	return removed
+}
+
+func (s *packedBullets) each(f func(*ManagedBullet)) {
+	for i, b := range s.bullets {
+		if !s.vanished[i] {
+			f(b)
+		}
+	}
+}
+
+// sync writes the position in the packed storage to the runner.
+func (r *runner) sync() {
+	if s := r.packed; s != nil {
+		r.bullet.x, r.bullet.y = s.x[r.packedIndex], s.y[r.packedIndex]
+		r.tickProgress = s.progress[r.packedIndex]
+	}
+}
+
+// unpack takes the runner out of the packed storage and gives it back to the manager,
+// which is needed before its state is changed.
+func (r *runner) unpack() {
+	s := r.packed
+	if s == nil {
+		return
+	}
+
+	r.sync()
+
+	i := r.packedIndex
+	s.manager.bullets = append(s.manager.bullets, s.bullets[i])
+	s.runners[i], s.bullets[i] = nil, nil
+	s.vanished[i] = true
+	r.packed = nil
+}
+
+// markVanished vanishes the bullet without calling hooks.
+func (r *runner) markVanished() {
+	r.bullet.vanished = true
+	if r.packed != nil {
+		r.packed.vanished[r.packedIndex] = true
+	}
+}
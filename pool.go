@@ -0,0 +1,151 @@
+package bulletml
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// freeList keeps recycled runners and parameters for reuse. Unlike sync.Pool, it isn't
+// emptied by garbage collection, so updates don't allocate once the number of bullets is steady.
+// It is shared by the runners created by a NewRunner call, or by all runners of a Manager,
+// and is locked because the workers of Manager fire bullets concurrently.
+type freeList struct {
+	mu      sync.Mutex
+	runners []*runner
+	params  []parameters
+}
+
+// newBulletRunner returns a runner for a new bullet, reusing a released one if available.
+func newBulletRunner(config *runnerConfig) *runner {
+	l := config.pool
+	l.mu.Lock()
+	var r *runner
+	if n := len(l.runners); n > 0 {
+		r = l.runners[n-1]
+		l.runners[n-1] = nil
+		l.runners = l.runners[:n-1]
+	}
+	l.mu.Unlock()
+	if r == nil {
+		return createRunner(config, &bulletModel{})
+	}
+
+	*r = runner{
+		family:               family{children: r.children},
+		config:               config,
+		bullet:               r.bullet,
+		bulletVxCache:        math.NaN(),
+		bulletVyCache:        math.NaN(),
+		stack:                r.stack,
+		deferred:             r.deferred[:0],
+		waitUntil:            -1,
+		changeSpeedUntil:     -1,
+		changeDirectionUntil: -1,
+		accelUntil:           -1,
+		homingUntil:          -1,
+		generation:           r.generation,
+		pins:                 r.pins,
+	}
+	*r.bullet = bulletModel{}
+
+	return r
+}
+
+// Release vanishes the bullet and lets the runner be reused for bullets fired later.
+// The runner must not be used after calling it.
+func (r *runner) Release() {
+	r.recordChange(changeRelease, 0, 0)
+	r.vanish()
+	r.released = true
+}
+
+// pinned returns whether snapshots refer to the runner.
+// The count is atomic because it is decreased by the finalizers of snapshots.
+func (r *runner) pinned() bool {
+	return r.pins != nil && atomic.LoadInt32(r.pins) > 0
+}
+
+// recycle puts the runner back to the free list. It is called when the parent forgets
+// the runner, which means all of its descendants have vanished.
+func (r *runner) recycle() {
+	for i, c := range r.children {
+		c.parent = nil
+		r.children[i] = nil
+	}
+	r.children = r.children[:0]
+
+	for _, p := range r.stack {
+		p.release()
+	}
+	r.stack = r.stack[:0]
+
+	for i := range r.deferred {
+		r.deferred[i] = deferredFire{}
+	}
+	r.deferred = r.deferred[:0]
+
+	r.parent = nil
+	r.fireContext = FireContext{}
+	r.generation++
+
+	l := r.config.pool
+	l.mu.Lock()
+	l.runners = append(l.runners, r)
+	l.mu.Unlock()
+}
+
+func (l *freeList) newParameters() parameters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(l.params)
+	if n == 0 {
+		return make(parameters)
+	}
+	params := l.params[n-1]
+	l.params[n-1] = nil
+	l.params = l.params[:n-1]
+	return params
+}
+
+func (l *freeList) releaseParameters(params parameters) {
+	if params == nil {
+		return
+	}
+	for k := range params {
+		delete(params, k)
+	}
+
+	l.mu.Lock()
+	l.params = append(l.params, params)
+	l.mu.Unlock()
+}
+
+func (l *freeList) copyParameters(params parameters) parameters {
+	if params == nil {
+		return nil
+	}
+	copied := l.newParameters()
+	for k, v := range params {
+		copied[k] = v
+	}
+	return copied
+}
+
+var paramNames [16]string
+
+func init() {
+	for i := range paramNames {
+		paramNames[i] = fmt.Sprintf("$%d", i+1)
+	}
+}
+
+// paramName returns the name of the i-th (zero-based) parameter.
+func paramName(i int) string {
+	if i < len(paramNames) {
+		return paramNames[i]
+	}
+	return fmt.Sprintf("$%d", i+1)
+}
@@ -0,0 +1,32 @@
+package bulletml
+
+// randomSource is a rand.Source whose state can be saved and restored.
+// It implements SplitMix64.
+type randomSource struct {
+	state uint64
+}
+
+func newRandomSource(seed int64) *randomSource {
+	return &randomSource{state: uint64(seed)}
+}
+
+func (s *randomSource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *randomSource) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Float64 returns a number in [0.0, 1.0).
+func (s *randomSource) Float64() float64 {
+	return float64(s.Uint64()>>11) / (1 << 53)
+}
+
+func (s *randomSource) Seed(seed int64) {
+	s.state = uint64(seed)
+}
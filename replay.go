@@ -0,0 +1,813 @@
+package bulletml
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	replayMagic   = "BMLP"
+	replayVersion = 1
+)
+
+// Recorder runs BulletML and records the inputs given by the host in every tick,
+// so that the pattern can be re-run by Replay.Verify.
+// Changes of bullets by the host, e.g. BulletRunner.Vanish for culling or SetSpeed,
+// are recorded as inputs too.
+//
+// Recorder updates the runner and all of its descendants by itself, so the host
+// must not call Update of the bullet runners.
+type Recorder struct {
+	runner Runner
+	replay *Replay
+	tick   *replayTick
+
+	// bullets numbers the bullets in the order of firing to identify them in changes.
+	bullets map[BulletRunner]uint64
+	fired   uint64
+	fresh   []BulletRunner
+
+	// calls is the number of hooks called in the current tick, which tells when changes are made.
+	// Inputs are recorded only while updating, since Verify asks for them only in updates.
+	calls    int
+	updating bool
+	pending  []replayChange
+}
+
+// NewRecorder creates a new Recorder. The options are the same as NewRunner.
+func NewRecorder(bulletML *BulletML, opts *NewRunnerOptions) (*Recorder, error) {
+	_opts := *opts
+	rec := &Recorder{
+		replay: &Replay{
+			defaultBulletSpeed: opts.DefaultBulletSpeed,
+			rank:               opts.Rank,
+			deterministicMath:  opts.DeterministicMath,
+			moveShooter:        opts.MoveShooter,
+			targetSelection:    opts.TargetSelection,
+		},
+	}
+
+	if opts.CurrentShootPosition != nil {
+		_opts.CurrentShootPosition = func() (float64, float64) {
+			x, y := opts.CurrentShootPosition()
+			if rec.updating {
+				rec.tick.shootPositions = append(rec.tick.shootPositions, x, y)
+			}
+			return x, y
+		}
+	}
+	if opts.CurrentTargetPosition != nil {
+		rec.replay.hasTargetPosition = true
+		_opts.CurrentTargetPosition = func() (float64, float64) {
+			x, y := opts.CurrentTargetPosition()
+			if rec.updating {
+				rec.tick.targetPositions = append(rec.tick.targetPositions, x, y)
+			}
+			return x, y
+		}
+	}
+	if opts.CurrentTargetVelocity != nil {
+		rec.replay.hasTargetVelocity = true
+		_opts.CurrentTargetVelocity = func() (float64, float64) {
+			vx, vy := opts.CurrentTargetVelocity()
+			if rec.updating {
+				rec.tick.targetVelocities = append(rec.tick.targetVelocities, vx, vy)
+			}
+			return vx, vy
+		}
+	}
+	if opts.Targets != nil {
+		rec.replay.hasTargets = true
+		_opts.Targets = func() []Target {
+			targets := opts.Targets()
+			if rec.updating {
+				rec.tick.targets = append(rec.tick.targets, float64(len(targets)))
+				for _, t := range targets {
+					rec.tick.targets = append(rec.tick.targets, t.X, t.Y, t.VX, t.VY)
+				}
+			}
+			return targets
+		}
+	}
+
+	if opts.Emitters != nil {
+		_opts.Emitters = make(map[string]*Emitter, len(opts.Emitters))
+		for _, name := range sortedKeys(opts.Emitters) {
+			e := opts.Emitters[name]
+			if e == nil {
+				continue
+			}
+
+			recorded := &Emitter{}
+			if e.Position != nil {
+				recorded.Position = func() (float64, float64) {
+					x, y := e.Position()
+					if rec.updating {
+						rec.tick.emitterValues = append(rec.tick.emitterValues, x, y)
+					}
+					return x, y
+				}
+			}
+			if e.Direction != nil {
+				recorded.Direction = func() float64 {
+					d := e.Direction()
+					if rec.updating {
+						rec.tick.emitterValues = append(rec.tick.emitterValues, d)
+					}
+					return d
+				}
+			}
+			_opts.Emitters[name] = recorded
+			rec.replay.emitters = append(rec.replay.emitters, replayEmitter{
+				name:         name,
+				hasDirection: e.Direction != nil,
+			})
+		}
+	}
+
+	rec.bullets = make(map[BulletRunner]uint64)
+	wrapHooks(&_opts, func() { rec.calls++ }, func(b BulletRunner) {
+		rec.bullets[b] = rec.fired
+		rec.fired++
+		rec.fresh = append(rec.fresh, b)
+	}, func(b BulletRunner) {
+		delete(rec.bullets, b)
+	})
+	_opts.onChange = rec.recordChange
+
+	random := opts.Random
+	if random == nil {
+		seed := opts.Seed
+		if seed == 0 {
+			seed = time.Now().Unix()
+		}
+		random = rand.New(newRandomSource(seed))
+	}
+	_opts.Random = rand.New(&recordingSource{random: random, recorder: rec})
+
+	// NewRunner asks positions of the shooter for initial state
+	rec.tick, rec.updating = &replayTick{}, true
+	r, err := NewRunner(bulletML, &_opts)
+	rec.updating = false
+	if err != nil {
+		return nil, err
+	}
+	rec.replay.initial = *rec.tick
+	rec.replay.fingerprint = r.(*multiRunner).config.documentIndex().fingerprint
+	rec.runner = r
+
+	return rec, nil
+}
+
+// Runner returns the runner run by the recorder.
+func (rec *Recorder) Runner() Runner {
+	return rec.runner
+}
+
+// Update updates the runner and all of its descendants, and records the inputs.
+func (rec *Recorder) Update() error {
+	return rec.UpdateDelta(1)
+}
+
+// UpdateDelta is the same as Update but advances dt ticks like Runner.UpdateDelta.
+func (rec *Recorder) UpdateDelta(dt float64) error {
+	rec.replay.ticks = append(rec.replay.ticks, replayTick{dt: dt, changes: rec.pending})
+	rec.tick = &rec.replay.ticks[len(rec.replay.ticks)-1]
+	rec.pending = nil
+
+	rec.calls, rec.updating = 0, true
+	hash, err := updateTree(rec.runner, dt, &rec.fresh)
+	rec.updating = false
+	if err != nil {
+		return err
+	}
+	rec.tick.hash = hash
+
+	return nil
+}
+
+func (rec *Recorder) recordChange(r *runner, op changeOp, a, b float64) {
+	id, ok := rec.bullets[r]
+	if !ok {
+		return
+	}
+
+	c := replayChange{bullet: id, op: op, a: a, b: b}
+	if rec.updating {
+		c.call = rec.calls
+		rec.tick.changes = append(rec.tick.changes, c)
+	} else {
+		// Changes between ticks are made before the next tick
+		rec.pending = append(rec.pending, c)
+	}
+}
+
+// wrapHooks makes all hooks call called before the host's one, and fired and vanished
+// when a bullet is fired and vanishes.
+func wrapHooks(opts *NewRunnerOptions, called func(), fired, vanished func(BulletRunner)) {
+	onBulletFired := opts.OnBulletFired
+	opts.OnBulletFired = func(b BulletRunner, ctx *FireContext) {
+		fired(b)
+		called()
+		onBulletFired(b, ctx)
+	}
+	onVanish := opts.OnVanish
+	opts.OnVanish = func(r Runner, v *Vanish) {
+		if b, ok := r.(BulletRunner); ok {
+			vanished(b)
+		}
+		called()
+		if onVanish != nil {
+			onVanish(r, v)
+		}
+	}
+	opts.OnActionEnter = wrapHook(opts.OnActionEnter, called)
+	opts.OnActionExit = wrapHook(opts.OnActionExit, called)
+	opts.OnWaitStart = wrapHook(opts.OnWaitStart, called)
+	opts.OnChangeSpeed = wrapHook(opts.OnChangeSpeed, called)
+	opts.OnChangeDirection = wrapHook(opts.OnChangeDirection, called)
+	opts.OnAccel = wrapHook(opts.OnAccel, called)
+	opts.OnHoming = wrapHook(opts.OnHoming, called)
+	onCompleted := opts.OnCompleted
+	opts.OnCompleted = func(r Runner) {
+		called()
+		if onCompleted != nil {
+			onCompleted(r)
+		}
+	}
+}
+
+func wrapHook[T any](f func(Runner, T), called func()) func(Runner, T) {
+	return func(r Runner, v T) {
+		called()
+		if f != nil {
+			f(r, v)
+		}
+	}
+}
+
+// Replay returns the recorded data.
+func (rec *Recorder) Replay() *Replay {
+	return rec.replay
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type recordingSource struct {
+	random   *rand.Rand
+	recorder *Recorder
+}
+
+func (s *recordingSource) Int63() int64 {
+	v := s.random.Int63()
+	if s.recorder.updating {
+		s.recorder.tick.randoms = append(s.recorder.tick.randoms, v)
+	}
+	return v
+}
+
+func (s *recordingSource) Seed(seed int64) {
+	s.random.Seed(seed)
+}
+
+// updateTree updates the runner and its descendants and returns the hash of the bullet positions.
+// fresh collects the bullets fired during the update, which are updated again for the rest of dt.
+func updateTree(r Runner, dt float64, fresh *[]BulletRunner) (uint64, error) {
+	if err := r.UpdateDelta(dt); err != nil {
+		return 0, err
+	}
+
+	var descendants []BulletRunner
+	r.WalkDescendants(func(b BulletRunner) bool {
+		descendants = append(descendants, b)
+		return true
+	})
+	*fresh = (*fresh)[:0]
+
+	for len(descendants) > 0 {
+		for _, b := range descendants {
+			if b.Vanished() {
+				continue
+			}
+			if err := b.UpdateDelta(dt); err != nil {
+				return 0, err
+			}
+		}
+		descendants = append(descendants[:0], *fresh...)
+		*fresh = (*fresh)[:0]
+	}
+
+	h := fnv.New64a()
+	var buf []byte
+	r.WalkDescendants(func(b BulletRunner) bool {
+		x, y := b.Position()
+		buf = binary.LittleEndian.AppendUint64(buf[:0], math.Float64bits(x))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(y))
+		h.Write(buf)
+		return true
+	})
+
+	return h.Sum64(), nil
+}
+
+// Replay is a recording of the inputs given by the host and the bullet positions in each tick.
+type Replay struct {
+	fingerprint        uint64
+	defaultBulletSpeed float64
+	rank               float64
+	deterministicMath  bool
+	moveShooter        bool
+	hasTargetPosition  bool
+	hasTargetVelocity  bool
+	hasTargets         bool
+	targetSelection    TargetSelection
+	emitters           []replayEmitter
+	initial            replayTick
+	ticks              []replayTick
+}
+
+type replayEmitter struct {
+	name         string
+	hasDirection bool
+}
+
+type replayTick struct {
+	dt               float64
+	changes          []replayChange
+	shootPositions   []float64
+	targetPositions  []float64
+	targetVelocities []float64
+	targets          []float64
+	emitterValues    []float64
+	randoms          []int64
+	hash             uint64
+}
+
+type changeOp byte
+
+const (
+	changeVanish changeOp = iota
+	changeRelease
+	changeDirection
+	changeSpeed
+	changeAcceleration
+	changePolarAcceleration
+	changeVelocity
+)
+
+// replayChange is a change of a bullet made by the host.
+type replayChange struct {
+	// call is the number of hooks called in the tick before the change.
+	// It is 0 for changes made between ticks.
+	call int
+
+	// bullet is the number of the bullet in the order of firing.
+	bullet uint64
+
+	op   changeOp
+	a, b float64
+}
+
+func (c *replayChange) apply(r BulletRunner) {
+	switch c.op {
+	case changeVanish:
+		r.Vanish()
+	case changeRelease:
+		r.Release()
+	case changeDirection:
+		r.SetDirectionRadians(c.a)
+	case changeSpeed:
+		r.SetSpeed(c.a)
+	case changeAcceleration:
+		r.SetAcceleration(c.a, c.b)
+	case changePolarAcceleration:
+		r.SetPolarAcceleration(c.a, c.b)
+	case changeVelocity:
+		r.SetVelocity(c.a, c.b)
+	}
+}
+
+// DesyncError is returned by Replay.Verify when the bullet positions differ from the recording.
+type DesyncError struct {
+	// Tick is the zero-based index of the first tick whose bullet positions differ.
+	Tick int
+
+	// Expected is the hash of the recorded bullet positions.
+	Expected uint64
+
+	// Actual is the hash of the replayed bullet positions.
+	Actual uint64
+
+	// Input is the name of the input, e.g. "random", which the replay asked for a different
+	// number of times than recorded. It is empty if only the bullet positions differ.
+	Input string
+}
+
+func (e *DesyncError) Error() string {
+	if e.Input != "" {
+		return fmt.Sprintf("Desync detected at tick %d (%s inputs differ in number from the recording)", e.Tick, e.Input)
+	}
+	return fmt.Sprintf("Desync detected at tick %d (expected hash %016x, actual %016x)", e.Tick, e.Expected, e.Actual)
+}
+
+// Len returns the number of recorded ticks.
+func (rp *Replay) Len() int {
+	return len(rp.ticks)
+}
+
+// Hash returns the hash of the bullet positions after the tick.
+func (rp *Replay) Hash(tick int) uint64 {
+	return rp.ticks[tick].hash
+}
+
+// Verify re-runs the recorded inputs without the host and checks the bullet positions
+// in every tick. It returns *DesyncError if they differ from the recording.
+func (rp *Replay) Verify(bulletML *BulletML) error {
+	p := &replayPlayer{
+		tick:    &rp.initial,
+		bullets: make(map[uint64]BulletRunner),
+		ids:     make(map[BulletRunner]uint64),
+	}
+
+	opts := &NewRunnerOptions{
+		OnBulletFired:        func(BulletRunner, *FireContext) {},
+		CurrentShootPosition: p.shootPosition,
+		DefaultBulletSpeed:   rp.defaultBulletSpeed,
+		Random:               rand.New(p),
+		Rank:                 rp.rank,
+		DeterministicMath:    rp.deterministicMath,
+		MoveShooter:          rp.moveShooter,
+		TargetSelection:      rp.targetSelection,
+	}
+	if rp.hasTargetPosition {
+		opts.CurrentTargetPosition = p.targetPosition
+	}
+	if rp.hasTargetVelocity {
+		opts.CurrentTargetVelocity = p.targetVelocity
+	}
+	if rp.hasTargets {
+		opts.Targets = p.targets
+	}
+	wrapHooks(opts, p.called, func(b BulletRunner) {
+		p.bullets[p.fired], p.ids[b] = b, p.fired
+		p.fired++
+		p.fresh = append(p.fresh, b)
+	}, func(b BulletRunner) {
+		delete(p.bullets, p.ids[b])
+		delete(p.ids, b)
+	})
+	if rp.emitters != nil {
+		opts.Emitters = make(map[string]*Emitter, len(rp.emitters))
+		for _, e := range rp.emitters {
+			emitter := &Emitter{Position: p.emitterPosition}
+			if e.hasDirection {
+				emitter.Direction = p.emitterValue
+			}
+			opts.Emitters[e.name] = emitter
+		}
+	}
+
+	r, err := NewRunner(bulletML, opts)
+	if err != nil {
+		return err
+	}
+
+	if r.(*multiRunner).config.documentIndex().fingerprint != rp.fingerprint {
+		return errors.New("BulletML document has changed since the replay was recorded")
+	}
+
+	for i := range rp.ticks {
+		p.tick = &rp.ticks[i]
+		p.shootIndex, p.targetIndex, p.targetVelocityIndex, p.randomIndex = 0, 0, 0, 0
+		p.targetsIndex, p.emitterIndex, p.changeIndex = 0, 0, 0
+		p.exhausted = ""
+
+		p.calls, p.updating = 0, false
+		p.applyChanges()
+		p.updating = true
+		hash, err := updateTree(r, p.tick.dt, &p.fresh)
+		if err != nil {
+			return err
+		}
+
+		if input := p.mismatchedInput(); input != "" || hash != p.tick.hash {
+			return &DesyncError{Tick: i, Expected: p.tick.hash, Actual: hash, Input: input}
+		}
+	}
+
+	return nil
+}
+
+type replayPlayer struct {
+	tick                                 *replayTick
+	shootIndex, targetIndex, randomIndex int
+	targetVelocityIndex                  int
+	targetsIndex, emitterIndex           int
+	targetsBuf                           []Target
+
+	// exhausted is the name of the first input asked for more than recorded in the tick
+	exhausted string
+
+	bullets     map[uint64]BulletRunner
+	ids         map[BulletRunner]uint64
+	fired       uint64
+	fresh       []BulletRunner
+	calls       int
+	updating    bool
+	changeIndex int
+}
+
+// exhaust marks the input as asked for more than recorded.
+func (p *replayPlayer) exhaust(input string) {
+	if p.exhausted == "" {
+		p.exhausted = input
+	}
+}
+
+// mismatchedInput returns the name of the input which is not used as many times as recorded in the tick.
+func (p *replayPlayer) mismatchedInput() string {
+	switch {
+	case p.exhausted != "":
+		return p.exhausted
+	case p.shootIndex != len(p.tick.shootPositions):
+		return "shoot position"
+	case p.targetIndex != len(p.tick.targetPositions):
+		return "target position"
+	case p.targetVelocityIndex != len(p.tick.targetVelocities):
+		return "target velocity"
+	case p.targetsIndex != len(p.tick.targets):
+		return "targets"
+	case p.emitterIndex != len(p.tick.emitterValues):
+		return "emitter"
+	case p.randomIndex != len(p.tick.randoms):
+		return "random"
+	case p.changeIndex != len(p.tick.changes):
+		return "change"
+	}
+	return ""
+}
+
+func (p *replayPlayer) called() {
+	if p.updating {
+		p.calls++
+		p.applyChanges()
+	}
+}
+
+// applyChanges makes the changes made by the host at this point of the tick.
+func (p *replayPlayer) applyChanges() {
+	for p.changeIndex < len(p.tick.changes) && p.tick.changes[p.changeIndex].call == p.calls {
+		c := &p.tick.changes[p.changeIndex]
+		// Advance first because the change may call hooks
+		p.changeIndex++
+		if b, ok := p.bullets[c.bullet]; ok {
+			c.apply(b)
+		} else {
+			p.exhaust("change")
+		}
+	}
+}
+
+func (p *replayPlayer) shootPosition() (float64, float64) {
+	if p.shootIndex+2 > len(p.tick.shootPositions) {
+		p.exhaust("shoot position")
+		return 0, 0
+	}
+	x, y := p.tick.shootPositions[p.shootIndex], p.tick.shootPositions[p.shootIndex+1]
+	p.shootIndex += 2
+	return x, y
+}
+
+func (p *replayPlayer) targetPosition() (float64, float64) {
+	if p.targetIndex+2 > len(p.tick.targetPositions) {
+		p.exhaust("target position")
+		return 0, 0
+	}
+	x, y := p.tick.targetPositions[p.targetIndex], p.tick.targetPositions[p.targetIndex+1]
+	p.targetIndex += 2
+	return x, y
+}
+
+func (p *replayPlayer) targets() []Target {
+	if p.targetsIndex >= len(p.tick.targets) {
+		p.exhaust("targets")
+		return nil
+	}
+	// The count may be corrupt, e.g. negative or larger than the recorded targets
+	v := p.tick.targets[p.targetsIndex]
+	if !(v >= 0 && v <= float64((len(p.tick.targets)-p.targetsIndex-1)/4)) || v != math.Trunc(v) {
+		p.exhaust("targets")
+		return nil
+	}
+	n := int(v)
+
+	p.targetsBuf = p.targetsBuf[:0]
+	for i := 0; i < n; i++ {
+		v := p.tick.targets[p.targetsIndex+1+i*4:]
+		p.targetsBuf = append(p.targetsBuf, Target{X: v[0], Y: v[1], VX: v[2], VY: v[3]})
+	}
+	p.targetsIndex += 1 + n*4
+	return p.targetsBuf
+}
+
+func (p *replayPlayer) targetVelocity() (float64, float64) {
+	if p.targetVelocityIndex+2 > len(p.tick.targetVelocities) {
+		p.exhaust("target velocity")
+		return 0, 0
+	}
+	vx, vy := p.tick.targetVelocities[p.targetVelocityIndex], p.tick.targetVelocities[p.targetVelocityIndex+1]
+	p.targetVelocityIndex += 2
+	return vx, vy
+}
+
+func (p *replayPlayer) emitterPosition() (float64, float64) {
+	return p.emitterValue(), p.emitterValue()
+}
+
+func (p *replayPlayer) emitterValue() float64 {
+	if p.emitterIndex >= len(p.tick.emitterValues) {
+		p.exhaust("emitter")
+		return 0
+	}
+	v := p.tick.emitterValues[p.emitterIndex]
+	p.emitterIndex++
+	return v
+}
+
+func (p *replayPlayer) Int63() int64 {
+	if p.randomIndex >= len(p.tick.randoms) {
+		p.exhaust("random")
+		return 0
+	}
+	v := p.tick.randoms[p.randomIndex]
+	p.randomIndex++
+	return v
+}
+
+func (p *replayPlayer) Seed(int64) {}
+
+// MarshalBinary encodes the replay.
+func (rp *Replay) MarshalBinary() ([]byte, error) {
+	w := &binaryWriter{}
+
+	w.buf = append(w.buf, replayMagic...)
+	w.uvarint(replayVersion)
+	w.fixed64(rp.fingerprint)
+	w.float(rp.defaultBulletSpeed)
+	w.float(rp.rank)
+	w.bool(rp.deterministicMath)
+	w.bool(rp.moveShooter)
+	w.bool(rp.hasTargetPosition)
+	w.bool(rp.hasTargetVelocity)
+	w.bool(rp.hasTargets)
+	w.varint(int(rp.targetSelection))
+	w.uvarint(uint64(len(rp.emitters)))
+	for _, e := range rp.emitters {
+		w.string(e.name)
+		w.bool(e.hasDirection)
+	}
+
+	w.replayTick(&rp.initial)
+	w.uvarint(uint64(len(rp.ticks)))
+	for i := range rp.ticks {
+		w.replayTick(&rp.ticks[i])
+	}
+
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes the replay encoded by MarshalBinary.
+func (rp *Replay) UnmarshalBinary(data []byte) error {
+	if len(data) < len(replayMagic) || string(data[:len(replayMagic)]) != replayMagic {
+		return errors.New("Invalid data format")
+	}
+	r := &binaryReader{data: data[len(replayMagic):]}
+
+	if v := r.uvarint(); r.err == nil && v != replayVersion {
+		return fmt.Errorf("Unsupported data version: %d", v)
+	}
+
+	var loaded Replay
+	loaded.fingerprint = r.fixed64()
+	loaded.defaultBulletSpeed = r.float()
+	loaded.rank = r.float()
+	loaded.deterministicMath = r.bool()
+	loaded.moveShooter = r.bool()
+	loaded.hasTargetPosition = r.bool()
+	loaded.hasTargetVelocity = r.bool()
+	loaded.hasTargets = r.bool()
+	loaded.targetSelection = TargetSelection(r.varint())
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		loaded.emitters = append(loaded.emitters, replayEmitter{
+			name:         r.string(),
+			hasDirection: r.bool(),
+		})
+	}
+
+	r.replayTick(&loaded.initial)
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		var t replayTick
+		r.replayTick(&t)
+		loaded.ticks = append(loaded.ticks, t)
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+
+	*rp = loaded
+
+	return nil
+}
+
+func (w *binaryWriter) replayTick(t *replayTick) {
+	w.float(t.dt)
+	w.uvarint(uint64(len(t.shootPositions)))
+	for _, v := range t.shootPositions {
+		w.float(v)
+	}
+	w.uvarint(uint64(len(t.targetPositions)))
+	for _, v := range t.targetPositions {
+		w.float(v)
+	}
+	w.uvarint(uint64(len(t.targetVelocities)))
+	for _, v := range t.targetVelocities {
+		w.float(v)
+	}
+	w.uvarint(uint64(len(t.targets)))
+	for _, v := range t.targets {
+		w.float(v)
+	}
+	w.uvarint(uint64(len(t.emitterValues)))
+	for _, v := range t.emitterValues {
+		w.float(v)
+	}
+	w.uvarint(uint64(len(t.randoms)))
+	for _, v := range t.randoms {
+		w.uvarint(uint64(v))
+	}
+	w.uvarint(uint64(len(t.changes)))
+	for _, c := range t.changes {
+		w.uvarint(uint64(c.call))
+		w.uvarint(c.bullet)
+		w.uvarint(uint64(c.op))
+		w.float(c.a)
+		w.float(c.b)
+	}
+	w.fixed64(t.hash)
+}
+
+func (r *binaryReader) replayTick(t *replayTick) {
+	t.dt = r.float()
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		t.shootPositions = append(t.shootPositions, r.float())
+	}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		t.targetPositions = append(t.targetPositions, r.float())
+	}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		t.targetVelocities = append(t.targetVelocities, r.float())
+	}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		t.targets = append(t.targets, r.float())
+	}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		t.emitterValues = append(t.emitterValues, r.float())
+	}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		t.randoms = append(t.randoms, int64(r.uvarint()))
+	}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		c := replayChange{call: int(r.uvarint()), bullet: r.uvarint()}
+		if op := r.uvarint(); op > uint64(changeVelocity) && r.err == nil {
+			r.err = fmt.Errorf("Invalid change: %d", op)
+		} else {
+			c.op = changeOp(op)
+		}
+		c.a, c.b = r.float(), r.float()
+		t.changes = append(t.changes, c)
+	}
+	t.hash = r.fixed64()
+}
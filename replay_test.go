@@ -0,0 +1,74 @@
+package bulletml
+
+import (
+	"errors"
+	"testing"
+)
+
+const replayTestSrc = `<bulletml>
+<action label="top">
+  <repeat><times>20</times><action>
+    <fire offset="$target.x + $rand"><direction type="aim">$rand * 10</direction><speed>2</speed><bullet/></fire>
+    <wait>3</wait>
+  </action></repeat>
+</action>
+</bulletml>`
+
+func newReplayTestRecorder(t *testing.T, bml *BulletML) *Recorder {
+	t.Helper()
+	rec, err := NewRecorder(bml, &NewRunnerOptions{
+		OnBulletFired:         func(BulletRunner, *FireContext) {},
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+		Targets:               func() []Target { return []Target{{X: -20, Y: 100}, {X: 20, Y: 100}} },
+		Seed:                  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestReplayInputsOutsideUpdate(t *testing.T) {
+	bml := loadTestBulletML(t, replayTestSrc)
+	rec := newReplayTestRecorder(t, bml)
+
+	for i := 0; i < 60; i++ {
+		if err := rec.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The host asks for inputs between ticks, which the replay doesn't need
+		rec.Runner().WalkDescendants(func(b BulletRunner) bool {
+			if _, err := b.FireContext().FloatAttr("offset", 0); err != nil {
+				t.Fatal(err)
+			}
+			return true
+		})
+	}
+
+	if err := rec.Replay().Verify(bml); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayCorruptTargets(t *testing.T) {
+	bml := loadTestBulletML(t, replayTestSrc)
+
+	for _, count := range []float64{-3, 1e300, 1.5, 1000} {
+		rec := newReplayTestRecorder(t, bml)
+		for i := 0; i < 10; i++ {
+			if err := rec.Update(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rp := rec.Replay()
+		rp.ticks[0].targets[0] = count
+
+		var desync *DesyncError
+		if err := rp.Verify(bml); !errors.As(err, &desync) || desync.Input != "targets" {
+			t.Errorf("count %v: got %v, want desync of targets", count, err)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package bulletml
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"go/ast"
@@ -9,6 +11,7 @@ import (
 	"go/token"
 	"math"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,6 +21,48 @@ type Runner interface {
 	// Update updates runner state. It should be called in every loop.
 	Update() error
 
+	// UpdateDelta advances runner state by dt ticks. Fractional ticks are accumulated,
+	// so a pattern looks the same at any update rate, e.g. UpdateDelta(60.0 / 144) at 144 Hz.
+	// Time scaling such as slow motion can be done by multiplying dt.
+	// Bullets fired in the update start at the time they are fired, so they should be
+	// updated with the same dt in the same loop, which advances them by the rest of dt.
+	UpdateDelta(dt float64) error
+
+	// Parent returns the runner which fired this runner's bullet.
+	// It returns nil for the runner created by NewRunner.
+	Parent() Runner
+
+	// Children returns the bullet runners fired by this runner which have not vanished.
+	Children() []BulletRunner
+
+	// WalkDescendants calls f for each bullet runner fired by this runner or its descendants
+	// in depth-first order. Vanished runners are skipped. Walking stops when f returns false.
+	WalkDescendants(f func(BulletRunner) bool)
+
+	// VanishDescendants vanishes all bullets fired by this runner or its descendants.
+	VanishDescendants()
+
+	// Snapshot saves the state of the runner and its descendants.
+	Snapshot() *Snapshot
+
+	// Restore restores the state saved by Snapshot. Bullets fired after the snapshot
+	// was taken are vanished, so the host should rebuild its bullet list with WalkDescendants.
+	// Runners created by Manager store the restored bullets in the manager again.
+	Restore(*Snapshot) error
+
+	// MarshalBinary encodes the state of the runner and its descendants.
+	// Actions are stored as IDs in the BulletML document, so the data can be loaded
+	// only by runners created from the same document.
+	encoding.BinaryMarshaler
+
+	// UnmarshalBinary restores the state encoded by MarshalBinary. Bullets fired
+	// before loading are vanished, so the host should rebuild its bullet list with WalkDescendants.
+	encoding.BinaryUnmarshaler
+
+	// Position returns the position (x, y) of the bullet, or the shooter for the runner created
+	// by NewRunner. The shooter is at CurrentShootPosition unless MoveShooter is set.
+	Position() (float64, float64)
+
 	completed() bool
 }
 
@@ -25,11 +70,63 @@ type Runner interface {
 type BulletRunner interface {
 	Runner
 
-	// Position returns the bullet position (x, y).
-	Position() (float64, float64)
-
 	// Vanished returns whether the bullet has vanished or not.
 	Vanished() bool
+
+	// Vanish vanishes the bullet.
+	// Bullets dropped by the host should be vanished so that their ancestors can forget them.
+	Vanish()
+
+	// FireContext returns the context passed to OnBulletFired when the bullet was fired.
+	// Bullets restored by UnmarshalBinary have it too, so the host can tell which
+	// <fire> and <bullet> elements they are from.
+	FireContext() *FireContext
+
+	// Release vanishes the bullet and lets the library reuse the runner for bullets fired later,
+	// which avoids allocations. The runner and its FireContext must not be used after calling it.
+	Release()
+
+	// Direction returns the bullet direction in BulletML degrees (0 is up, clockwise).
+	// Acceleration by <accel> is not included.
+	Direction() float64
+
+	// DirectionRadians returns the bullet direction in radians from the x axis toward the y axis.
+	DirectionRadians() float64
+
+	// Speed returns the bullet speed. Acceleration by <accel> is not included.
+	Speed() float64
+
+	// Acceleration returns the speed added by <accel> in x and y.
+	Acceleration() (float64, float64)
+
+	// Velocity returns the bullet movement per tick (vx, vy) including acceleration and force fields.
+	Velocity() (float64, float64)
+
+	// SetDirection sets the bullet direction in BulletML degrees.
+	// <changeDirection> in progress is cancelled. Non-finite directions are ignored.
+	SetDirection(float64)
+
+	// SetDirectionRadians is the same as SetDirection but takes radians like DirectionRadians.
+	SetDirectionRadians(float64)
+
+	// SetSpeed sets the bullet speed. <changeSpeed> in progress is cancelled.
+	SetSpeed(float64)
+
+	// SetAcceleration sets the speed added by <accel> in x and y.
+	// <accel> in progress is cancelled.
+	SetAcceleration(float64, float64)
+
+	// PolarAcceleration returns the speed added by <accel> along the bullet direction
+	// (tangential) and 90 degrees clockwise from it (normal).
+	PolarAcceleration() (float64, float64)
+
+	// SetPolarAcceleration sets the speed added by <accel> in tangential and normal.
+	// <accel> in progress is cancelled.
+	SetPolarAcceleration(float64, float64)
+
+	// SetVelocity sets the direction and speed so that the bullet moves (vx, vy)
+	// per tick with the current acceleration. Changes in progress are cancelled.
+	SetVelocity(float64, float64)
 }
 
 // FireContext contains context data of fire.
@@ -39,27 +136,178 @@ type FireContext struct {
 
 	// Bullet field is the <bullet> element fired by this event.
 	Bullet *Bullet
+
+	// Emitter is the name of the emitter which fired the bullet, or empty if the shooter fired it.
+	Emitter string
+
+	runner                   *runner
+	fireParams, bulletParams parameters
+
+	// random is the random generator of the fired bullet if perBulletRandom is enabled,
+	// which $rand in the attributes uses so that they don't depend on when they are evaluated
+	random *randomSource
+}
+
+// Attr returns the value of an extra attribute of the <fire> or <bullet> element,
+// e.g. sprite="rice". Attributes of <fire> take precedence over those of <bullet>.
+func (c *FireContext) Attr(name string) (string, bool) {
+	if v, exists := findAttr(c.Fire.Attrs, name); exists {
+		return v, true
+	}
+	return findAttr(c.Bullet.Attrs, name)
+}
+
+// FloatAttr evaluates the attribute as an expression with the parameters of the element,
+// e.g. damage="$1 * 2". It returns defaultValue if the attribute does not exist.
+// It must be called in OnBulletFired because the parameters may change afterwards.
+// For bullets of Manager, $rand draws from the random generator of the fired bullet,
+// so the values are the same for any number of workers.
+func (c *FireContext) FloatAttr(name string, defaultValue float64) (float64, error) {
+	var expr ast.Expr
+	var params parameters
+	var n node
+	if _, exists := findAttr(c.Fire.Attrs, name); exists {
+		expr, params, n = c.Fire.compiledAttrs[name], c.fireParams, c.Fire
+	} else if _, exists := findAttr(c.Bullet.Attrs, name); exists {
+		expr, params, n = c.Bullet.compiledAttrs[name], c.bulletParams, c.Bullet
+	} else {
+		return defaultValue, nil
+	}
+
+	if expr == nil {
+		v, _ := c.Attr(name)
+		return 0, newBulletmlError(fmt.Sprintf("Invalid expression in '%s' attribute: %s", name, v), n)
+	}
+
+	c.runner.attrRandom = c.random
+	v, _, err := evaluateExpr(expr, params, n, c.runner)
+	c.runner.attrRandom = nil
+	return v, err
+}
+
+// BoolAttr returns the attribute as a boolean, e.g. grazeable="false".
+// It returns defaultValue if the attribute does not exist.
+func (c *FireContext) BoolAttr(name string, defaultValue bool) (bool, error) {
+	v, exists := c.Attr(name)
+	if !exists {
+		return defaultValue, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+func findAttr(attrs []xml.Attr, name string) (string, bool) {
+	for _, attr := range attrs {
+		if attr.Name.Local == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
 }
 
 // NewRunnerOptions contains options for NewRunner function.
 type NewRunnerOptions struct {
 	// [Required] OnBulletFired is called when a bullet is fired.
+	// The runner which fired the bullet keeps it until it vanishes, so the host must call
+	// BulletRunner.Vanish or Release when it stops updating the bullet, e.g. off screen.
 	OnBulletFired func(BulletRunner, *FireContext)
 
 	// [Required] CurrentShootPosition tells the runner where the shooter is.
+	// If MoveShooter is set, it is called only once for the initial position.
 	CurrentShootPosition func() (float64, float64)
 
 	// [Required] CurrentTargetPosition tells the runner where the player is.
+	// It is optional if Targets is specified.
 	CurrentTargetPosition func() (float64, float64)
 
+	// CurrentTargetVelocity tells the runner how far the player moves per tick,
+	// which <direction type="predict"> uses to lead the player. The player is assumed
+	// to be still if nil.
+	CurrentTargetVelocity func() (float64, float64)
+
+	// Targets tells the runner where the players are, for games with more than one player.
+	// Bullets are aimed at the target chosen by TargetSelection when <fire> and <changeDirection> run,
+	// or the one specified by the 'target' attribute of <direction>, e.g. <direction type="aim" target="2">.
+	// Targets are numbered from 1 and indices out of range wrap around. $target.x and $target.y
+	// are the position of the chosen target, and $target(2).x is that of the second one.
+	// If it returns no targets, CurrentTargetPosition is used if specified,
+	// or the shooter's position otherwise.
+	Targets func() []Target
+
+	// TargetSelection is the policy to choose a target from Targets.
+	TargetSelection TargetSelection
+
+	// Emitters are named points which bullets are fired from instead of the shooter,
+	// chosen by the 'emitter' attribute of <fire>, e.g. <fire emitter="leftGun">, or of <action>
+	// for the <fire> elements in it. Aim and relative directions are based on the emitter.
+	// Only the top-level actions use emitters; bullets fire from their own positions.
+	Emitters map[string]*Emitter
+
 	// DefaultBulletSpeed is the default value of bullet speed. 1.0 is used if not specified.
 	DefaultBulletSpeed float64
 
 	// Random is used as a random generator in the runner.
+	// If specified, its state can't be saved, so Runner.Restore fails. Use Seed instead
+	// to restore snapshots, e.g. for rollback in lockstep games.
 	Random *rand.Rand
 
+	// Seed is the seed of the random generator used if Random is nil.
+	// The current time is used if 0. The state of the generator is saved in snapshots.
+	Seed int64
+
 	// Rank is the value for $rank.
 	Rank float64
+
+	// DeterministicMath makes the runner use trigonometric functions which return
+	// bit-identical results on all architectures, for lockstep multiplayer games.
+	DeterministicMath bool
+
+	// OnVanish is called when a bullet vanishes. The node is nil if the bullet was vanished
+	// by BulletRunner.Vanish.
+	OnVanish func(Runner, *Vanish)
+
+	// OnActionEnter is called when an action starts running.
+	OnActionEnter func(Runner, *Action)
+
+	// OnActionExit is called when an action finishes running.
+	OnActionExit func(Runner, *Action)
+
+	// OnWaitStart is called when a <wait> element suspends actions.
+	OnWaitStart func(Runner, *Wait)
+
+	// OnChangeSpeed is called when a <changeSpeed> element starts changing the speed.
+	OnChangeSpeed func(Runner, *ChangeSpeed)
+
+	// OnChangeDirection is called when a <changeDirection> element starts changing the direction.
+	OnChangeDirection func(Runner, *ChangeDirection)
+
+	// OnAccel is called when an <accel> element starts accelerating the bullet.
+	OnAccel func(Runner, *Accel)
+
+	// OnHoming is called when a <homing> element starts turning the bullet toward the target.
+	OnHoming func(Runner, *Homing)
+
+	// OnCompleted is called when all actions of a runner have finished.
+	//
+	// The runner passed to the hooks above is the one returned by NewRunner for top-level actions,
+	// and the BulletRunner of the bullet otherwise.
+	OnCompleted func(Runner)
+
+	// MoveShooter makes the top-level actions move the shooter like a bullet,
+	// so <changeSpeed>, <changeDirection> and <accel> in them take effect.
+	// The shooter starts at rest at CurrentShootPosition facing down (direction 180),
+	// and its position is returned by Runner.Position.
+	// All the top-level actions control the same shooter.
+	MoveShooter bool
+
+	// perBulletRandom gives each bullet its own random generator seeded by the runner
+	// which fired it, so that results do not depend on the order of updates.
+	perBulletRandom bool
+
+	// manager is the manager which created the runner, whose force fields move the bullets.
+	manager *Manager
+
+	// onChange is called when the host changes a bullet, so that Recorder can record it.
+	onChange func(r *runner, op changeOp, a, b float64)
 }
 
 // NewRunner creates a new Runner.
@@ -71,19 +319,28 @@ func NewRunner(bulletML *BulletML, opts *NewRunnerOptions) (Runner, error) {
 	if _opts.CurrentShootPosition == nil {
 		return nil, errors.New("CurrentShootPosition is required")
 	}
-	if _opts.CurrentTargetPosition == nil {
-		return nil, errors.New("CurrentTargetPosition is required")
+	if _opts.CurrentTargetPosition == nil && _opts.Targets == nil {
+		return nil, errors.New("CurrentTargetPosition or Targets is required")
 	}
 	if _opts.DefaultBulletSpeed == 0 {
 		_opts.DefaultBulletSpeed = 1.0
 	}
+	var randomSource *randomSource
 	if _opts.Random == nil {
-		_opts.Random = rand.New(rand.NewSource(time.Now().Unix()))
+		seed := _opts.Seed
+		if seed == 0 {
+			seed = time.Now().Unix()
+		}
+		randomSource = newRandomSource(seed)
+		_opts.Random = rand.New(randomSource)
 	}
 
 	if err := prepareNodeTree(bulletML); err != nil {
 		return nil, err
 	}
+	if err := checkEmitters(bulletML, _opts.Emitters); err != nil {
+		return nil, err
+	}
 
 	bulletDefTable := make(map[string]*Bullet)
 	for _, b := range bulletML.Bullets {
@@ -117,22 +374,52 @@ func NewRunner(bulletML *BulletML, opts *NewRunnerOptions) (Runner, error) {
 		actionDefTable: actionDefTable,
 		fireDefTable:   fireDefTable,
 		bulletDefTable: bulletDefTable,
-		updateBulletPosition: func(r *runner) {
+		randomSource:   randomSource,
+		index:          &documentIndex{},
+		math:           standardMath,
+		pool:           &freeList{},
+		updateBulletPosition: func(r *runner, step float64) {
 			x, y := r.config.opts.CurrentShootPosition()
 			r.bullet.x = x
 			r.bullet.y = y
 		},
 	}
 
-	m := &multiRunner{}
+	if _opts.DeterministicMath {
+		config.math = deterministicMath
+	}
+	if _opts.manager != nil {
+		config.pool = &_opts.manager.pool
+	}
+
+	bulletConfig := *config
+	bulletConfig.updateBulletPosition = updateBulletPosition
+	config.bulletConfig = &bulletConfig
+	bulletConfig.bulletConfig = &bulletConfig
+
+	if _opts.MoveShooter {
+		config.updateBulletPosition = updateShooterPosition
+	}
+
+	m := &multiRunner{config: config}
+	if _opts.MoveShooter {
+		m.shooter = &bulletModel{
+			direction: math.Pi / 2,
+		}
+		m.shooter.x, m.shooter.y = _opts.CurrentShootPosition()
+	}
 	for _, a := range topActions {
-		b := &bulletModel{
-			speed: _opts.DefaultBulletSpeed,
+		b := m.shooter
+		if b == nil {
+			b = &bulletModel{
+				speed: _opts.DefaultBulletSpeed,
+			}
+			b.x, b.y = _opts.CurrentShootPosition()
 		}
-		b.x, b.y = _opts.CurrentShootPosition()
 		r := createRunner(config, b)
+		r.host = m
 
-		r.pushStack(a, nil)
+		r.pushStack(a, nil, false)
 
 		m.runners = append(m.runners, r)
 	}
@@ -141,13 +428,33 @@ func NewRunner(bulletML *BulletML, opts *NewRunnerOptions) (Runner, error) {
 }
 
 type multiRunner struct {
-	runners []Runner
+	family
+
+	config  *runnerConfig
+	runners []*runner
+
+	// shooter is shared by the runners if MoveShooter is set,
+	// and mover is the one which moves it in the current update.
+	shooter *bulletModel
+	mover   *runner
 }
 
 func (m *multiRunner) Update() error {
+	return m.UpdateDelta(1)
+}
+
+func (m *multiRunner) UpdateDelta(dt float64) error {
+	if len(m.children) > 0 {
+		m.compact()
+	}
+
+	running := len(m.runners) > 0
+	if running {
+		m.mover = m.runners[0]
+	}
 	_runners := m.runners[:0]
 	for _, r := range m.runners {
-		if err := r.Update(); err != nil {
+		if err := r.UpdateDelta(dt); err != nil {
 			return err
 		}
 		if !r.completed() {
@@ -156,6 +463,12 @@ func (m *multiRunner) Update() error {
 	}
 	m.runners = _runners
 
+	if running && len(m.runners) == 0 {
+		if f := m.config.opts.OnCompleted; f != nil {
+			f(m)
+		}
+	}
+
 	return nil
 }
 
@@ -163,13 +476,31 @@ func (m *multiRunner) completed() bool {
 	return len(m.runners) == 0
 }
 
+func (m *multiRunner) Parent() Runner {
+	return nil
+}
+
+func (m *multiRunner) Position() (float64, float64) {
+	if m.shooter != nil {
+		return m.shooter.x, m.shooter.y
+	}
+	return m.config.opts.CurrentShootPosition()
+}
+
 type runnerConfig struct {
 	bulletML             *BulletML
 	opts                 *NewRunnerOptions
 	actionDefTable       map[string]*Action
 	fireDefTable         map[string]*Fire
 	bulletDefTable       map[string]*Bullet
-	updateBulletPosition func(*runner)
+	randomSource         *randomSource
+	index                *documentIndex
+	math                 *mathFuncs
+	pool                 *freeList
+	updateBulletPosition func(r *runner, step float64)
+
+	// bulletConfig is shared by all the bullets fired by runners with this config.
+	bulletConfig *runnerConfig
 }
 
 type bulletModel struct {
@@ -177,17 +508,40 @@ type bulletModel struct {
 	speed                                    float64
 	direction                                float64
 	accelSpeedHorizontal, accelSpeedVertical float64
-	vanished                                 bool
+	accelSpeedTangential, accelSpeedNormal   float64
+
+	// fieldVx and fieldVy are the velocity given by the force fields of Manager
+	fieldVx, fieldVy float64
+
+	vanished bool
+}
+
+// straight returns whether the bullet moves only at its speed in its direction.
+func (b *bulletModel) straight() bool {
+	return b.accelSpeedHorizontal == 0 && b.accelSpeedVertical == 0 &&
+		b.accelSpeedTangential == 0 && b.accelSpeedNormal == 0 &&
+		b.fieldVx == 0 && b.fieldVy == 0
 }
 
 type runner struct {
+	family
+
 	config *runnerConfig
 
+	// host is the runner returned by NewRunner if this runs a top-level action.
+	host   *multiRunner
+	parent Runner
+
 	bullet                       *bulletModel
 	bulletVxCache, bulletVyCache float64
 
-	ticks int
-	stack []*actionProcess
+	ticks        int
+	tickProgress float64
+	stack        []*actionProcess
+
+	// elapsed is the time passed in the current UpdateDelta, and spawnDelay is that of the parent
+	// when this bullet was fired, which is skipped in the first update in the same loop.
+	elapsed, spawnDelay float64
 
 	waitUntil int
 
@@ -200,10 +554,56 @@ type runner struct {
 	accelUntil                                  int
 	accelHorizontalDelta, accelHorizontalTarget float64
 	accelVerticalDelta, accelVerticalTarget     float64
+	accelTangentialDelta, accelTangentialTarget float64
+	accelNormalDelta, accelNormalTarget         float64
+
+	// The easings of the changes above, which are used instead of the deltas if active
+	changeSpeedEasing, changeDirectionEasing, accelEasing easing
+
+	// homingFraction is the fraction of the last tick of homing with a fractional term
+	homingUntil                                        int
+	homingTurnRate, homingAcceleration, homingFraction float64
 
 	lastFireDirection, lastFireSpeed float64
 
+	// waitCarry is how many ticks the actions resumed later than the exact time
+	// because of fractional waits.
+	waitCarry float64
+
+	// target is the zero-based index of the target chosen by TargetSelection,
+	// and targetTurn counts the choices used for TargetSelectionRoundRobin.
+	// targetPending is set while the command which chose the target hasn't used it.
+	target, targetTurn int
+	targetPending      bool
+
 	allActionsCompleted bool
+
+	// fireContext is passed to OnBulletFired when this bullet is fired.
+	fireContext FireContext
+
+	// released is set when the host has released the runner. It is recycled
+	// when its parent forgets it, and generation is incremented.
+	released   bool
+	generation uint64
+
+	// managed is set while Manager stores the bullet.
+	managed bool
+
+	// pins counts the snapshots which refer to the runner. Pinned runners are not recycled
+	// so that the snapshots can be restored.
+	pins *int32
+
+	// packed is the storage of Manager which moves this bullet after all actions have finished.
+	packed      *packedBullets
+	packedIndex int
+
+	// random is the random generator of this bullet if perBulletRandom is enabled,
+	// and attrRandom replaces it while FireContext evaluates attributes.
+	random     randomSource
+	attrRandom *randomSource
+
+	// deferred holds the bullets fired by this runner while Manager updates bullets in parallel.
+	deferred []deferredFire
 }
 
 func createRunner(config *runnerConfig, bullet *bulletModel) *runner {
@@ -216,38 +616,39 @@ func createRunner(config *runnerConfig, bullet *bulletModel) *runner {
 		changeSpeedUntil:     -1,
 		changeDirectionUntil: -1,
 		accelUntil:           -1,
+		homingUntil:          -1,
 	}
 
 	return r
 }
 
-func (r *runner) lookUpBulletDefTable(node node, params parameters) (*Bullet, parameters, bool, error) {
-	if b, ok := node.(*Bullet); ok {
+func (r *runner) lookUpBulletDefTable(v any, params parameters) (*Bullet, parameters, bool, error) {
+	if b, ok := v.(*Bullet); ok {
 		return b, params, true, nil
-	} else if b, ok := node.(*BulletRef); ok {
+	} else if b, ok := v.(*BulletRef); ok {
 		return lookUpDefTable(b, r.config.bulletDefTable, params, r)
 	} else {
-		return nil, nil, false, newBulletmlError(fmt.Sprintf("Invalid type: %T", node), node)
+		return nil, nil, false, newBulletmlError(fmt.Sprintf("Invalid type: %T", v), v.(node))
 	}
 }
 
-func (r *runner) lookUpActionDefTable(node node, params parameters) (*Action, parameters, bool, error) {
-	if a, ok := node.(*Action); ok {
+func (r *runner) lookUpActionDefTable(v any, params parameters) (*Action, parameters, bool, error) {
+	if a, ok := v.(*Action); ok {
 		return a, params, true, nil
-	} else if a, ok := node.(*ActionRef); ok {
+	} else if a, ok := v.(*ActionRef); ok {
 		return lookUpDefTable(a, r.config.actionDefTable, params, r)
 	} else {
-		return nil, nil, false, newBulletmlError(fmt.Sprintf("Invalid type: %T", node), node)
+		return nil, nil, false, newBulletmlError(fmt.Sprintf("Invalid type: %T", v), v.(node))
 	}
 }
 
-func (r *runner) lookUpFireDefTable(node node, params parameters) (*Fire, parameters, bool, error) {
-	if f, ok := node.(*Fire); ok {
+func (r *runner) lookUpFireDefTable(v any, params parameters) (*Fire, parameters, bool, error) {
+	if f, ok := v.(*Fire); ok {
 		return f, params, true, nil
-	} else if f, ok := node.(*FireRef); ok {
+	} else if f, ok := v.(*FireRef); ok {
 		return lookUpDefTable(f, r.config.fireDefTable, params, r)
 	} else {
-		return nil, nil, false, newBulletmlError(fmt.Sprintf("Invalid type: %T", node), node)
+		return nil, nil, false, newBulletmlError(fmt.Sprintf("Invalid type: %T", v), v.(node))
 	}
 }
 
@@ -267,38 +668,106 @@ func lookUpDefTable[T any, R refType](ref R, table map[string]*T, params paramet
 		return nil, nil, false, newBulletmlError(fmt.Sprintf("<%s label=\"%s\"> not found", ref.xmlName(), ref.label()), ref)
 	}
 
-	refParams := make(parameters)
+	var refParams parameters
 	dc := true
 	for i, p := range ref.params() {
 		v, d, err := evaluateExpr(p.compiledExpr, params, p, runner)
 		if err != nil {
+			runner.config.pool.releaseParameters(refParams)
 			return nil, nil, false, err
 		}
 
-		refParams[fmt.Sprintf("$%d", i+1)] = v
+		if refParams == nil {
+			refParams = runner.config.pool.newParameters()
+		}
+		refParams[paramName(i)] = v
 		dc = dc && d
 	}
 
 	return t, refParams, dc, nil
 }
 
-func (r *runner) pushStack(action *Action, params parameters) {
-	p := &actionProcess{
-		action: action,
-		params: params,
-		runner: r,
+// pushStack pushes a process of the action. If ownsParams is true, params are
+// released when the process ends.
+func (r *runner) pushStack(action *Action, params parameters, ownsParams bool) {
+	// Processes popped before are reused
+	var p *actionProcess
+	if n := len(r.stack); n < cap(r.stack) {
+		p = r.stack[:n+1][n]
+	}
+	if p == nil {
+		p = &actionProcess{}
+	}
+
+	*p = actionProcess{
+		action:     action,
+		params:     params,
+		ownsParams: ownsParams,
+		runner:     r,
 	}
 
 	r.stack = append(r.stack, p)
 }
 
 func (r *runner) Update() error {
+	return r.UpdateDelta(1)
+}
+
+// tickEpsilon absorbs rounding errors of accumulated fractional ticks.
+const tickEpsilon = 1e-9
+
+func (r *runner) UpdateDelta(dt float64) error {
+	r.unpack()
+
+	// Vanished children are forgotten here so that runners which stop firing don't keep them.
+	// Manager does it after parallel updates instead, since other workers may be updating them.
+	if len(r.children) > 0 && !r.deferring() {
+		r.compact()
+	}
+
+	r.elapsed = math.Min(r.spawnDelay, math.Max(dt, 0))
+	r.spawnDelay -= r.elapsed
+	dt -= r.elapsed
+
+	for dt > tickEpsilon {
+		if r.tickProgress == 0 {
+			if err := r.beginTick(); err != nil {
+				return err
+			}
+		}
+
+		step := 1 - r.tickProgress
+		if dt < step-tickEpsilon {
+			step = dt
+		}
+
+		r.config.updateBulletPosition(r, step)
+
+		r.tickProgress += step
+		r.elapsed += step
+		dt -= step
+
+		if r.tickProgress >= 1-tickEpsilon {
+			r.tickProgress = 0
+			r.endTick()
+		}
+	}
+
+	return nil
+}
+
+// beginTick runs actions and interpolations at the beginning of a tick.
+func (r *runner) beginTick() error {
 	if r.ticks > r.waitUntil {
 		for len(r.stack) > 0 {
 			top := r.stack[len(r.stack)-1]
 			if err := top.update(); err != nil {
 				if err == actionProcessEnd {
 					r.stack = r.stack[:len(r.stack)-1]
+					if f := r.config.opts.OnActionExit; f != nil {
+						f(r.self(), top.action)
+					}
+					top.release()
 				} else if err == actionProcessWait {
 					break
 				} else {
@@ -309,7 +778,11 @@ func (r *runner) Update() error {
 	}
 
 	if r.ticks < r.changeSpeedUntil {
-		r.bullet.speed += r.changeSpeedDelta
+		if r.changeSpeedEasing.active() {
+			r.bullet.speed = r.changeSpeedEasing.value(0, r.ticks)
+		} else {
+			r.bullet.speed += r.changeSpeedDelta
+		}
 		r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
 	} else if r.ticks == r.changeSpeedUntil {
 		r.bullet.speed = r.changeSpeedTarget
@@ -317,38 +790,124 @@ func (r *runner) Update() error {
 	}
 
 	if r.ticks < r.changeDirectionUntil {
-		r.bullet.direction += r.changeDirectionDelta
+		if r.changeDirectionEasing.active() {
+			r.bullet.direction = normalizeDir(r.changeDirectionEasing.value(0, r.ticks))
+		} else {
+			r.bullet.direction += r.changeDirectionDelta
+		}
 		r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
 	} else if r.ticks == r.changeDirectionUntil {
 		r.bullet.direction = r.changeDirectionTarget
 		r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
 	}
 
+	if r.ticks < r.homingUntil {
+		r.home(1)
+	} else if r.ticks == r.homingUntil && r.homingFraction > 0 {
+		r.home(r.homingFraction)
+	}
+
 	if r.ticks < r.accelUntil {
-		r.bullet.accelSpeedHorizontal += r.accelHorizontalDelta
-		r.bullet.accelSpeedVertical += r.accelVerticalDelta
+		if r.accelEasing.active() {
+			r.bullet.accelSpeedHorizontal = r.accelEasing.value(0, r.ticks)
+			r.bullet.accelSpeedVertical = r.accelEasing.value(1, r.ticks)
+			r.bullet.accelSpeedTangential = r.accelEasing.value(2, r.ticks)
+			r.bullet.accelSpeedNormal = r.accelEasing.value(3, r.ticks)
+		} else {
+			r.bullet.accelSpeedHorizontal += r.accelHorizontalDelta
+			r.bullet.accelSpeedVertical += r.accelVerticalDelta
+			r.bullet.accelSpeedTangential += r.accelTangentialDelta
+			r.bullet.accelSpeedNormal += r.accelNormalDelta
+		}
 		r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
 	} else if r.ticks == r.accelUntil {
 		r.bullet.accelSpeedHorizontal = r.accelHorizontalTarget
 		r.bullet.accelSpeedVertical = r.accelVerticalTarget
+		r.bullet.accelSpeedTangential = r.accelTangentialTarget
+		r.bullet.accelSpeedNormal = r.accelNormalTarget
 		r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
 	}
 
-	r.config.updateBulletPosition(r)
+	return nil
+}
 
+func (r *runner) endTick() {
 	if !r.allActionsCompleted {
 		if len(r.stack) == 0 &&
 			r.ticks > r.waitUntil &&
 			r.ticks > r.changeSpeedUntil &&
 			r.ticks > r.changeDirectionUntil &&
-			r.ticks > r.accelUntil {
+			r.ticks > r.accelUntil &&
+			r.ticks >= r.homingUntil {
 			r.allActionsCompleted = true
+			if f := r.config.opts.OnCompleted; f != nil && r.host == nil {
+				f(r)
+			}
 		}
 	}
 
 	r.ticks++
+}
 
-	return nil
+// wait suspends actions for the ticks. Waits are rounded up to whole ticks and
+// the excess is subtracted from the next wait, so fractional waits are honoured on average.
+// Waits within tickEpsilon of whole ticks are whole, e.g. $rank * 3 * 10 for rank 0.1.
+func (r *runner) wait(ticks float64) {
+	if ticks < 0 {
+		ticks = 0
+	}
+
+	exact := ticks - r.waitCarry
+	if rounded := math.Round(exact); math.Abs(exact-rounded) < tickEpsilon {
+		exact = rounded
+	}
+	n := math.Max(math.Ceil(exact), 0)
+	r.waitCarry = n - exact
+	r.waitUntil = r.ticks + int(n)
+}
+
+// changeUntil returns the tick when a change over term ticks finishes.
+// Changes with term <= 0 finish immediately, and the last tick of a
+// fractional term applies the remaining fraction.
+func (r *runner) changeUntil(term float64) int {
+	if term <= 0 {
+		return r.ticks
+	}
+	return r.ticks + int(term)
+}
+
+// termDelta returns the amount of change per tick to change by diff in term ticks.
+func termDelta(diff, term float64) float64 {
+	if term <= 0 {
+		return 0
+	}
+	return diff / term
+}
+
+// home turns the bullet toward the target by at most the turn rate, and accelerates it
+// toward the target. scale is the fraction of the tick which homing lasts.
+func (r *runner) home(scale float64) {
+	m := r.config.math
+	b := r.bullet
+	tx, ty := r.targetPosition(r.target)
+	angle := m.atan2(ty-b.y, tx-b.x)
+
+	rate := float64(r.homingTurnRate * scale)
+	diff := normalizeDir(angle - b.direction)
+	diff = math.Max(-rate, math.Min(rate, diff))
+	b.direction = normalizeDir(b.direction + diff)
+
+	// The acceleration is added to the velocity as a vector, so it also bends the course
+	if a := float64(r.homingAcceleration * scale); a != 0 {
+		vx := float64(b.speed*m.cos(b.direction)) + float64(a*m.cos(angle))
+		vy := float64(b.speed*m.sin(b.direction)) + float64(a*m.sin(angle))
+		b.speed = math.Sqrt(float64(vx*vx) + float64(vy*vy))
+		if vx != 0 || vy != 0 {
+			b.direction = m.atan2(vy, vx)
+		}
+	}
+
+	r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
 }
 
 func (r *runner) completed() bool {
@@ -356,13 +915,249 @@ func (r *runner) completed() bool {
 }
 
 func (r *runner) Position() (float64, float64) {
+	if s := r.packed; s != nil {
+		return s.x[r.packedIndex], s.y[r.packedIndex]
+	}
 	return r.bullet.x, r.bullet.y
 }
 
+func (r *runner) FireContext() *FireContext {
+	return &r.fireContext
+}
+
 func (r *runner) Vanished() bool {
 	return r.bullet.vanished
 }
 
+func (r *runner) Vanish() {
+	r.recordChange(changeVanish, 0, 0)
+	r.vanish()
+}
+
+func (r *runner) vanish() {
+	if !r.bullet.vanished {
+		r.markVanished()
+		if f := r.config.opts.OnVanish; f != nil {
+			f(r.self(), nil)
+		}
+	}
+}
+
+func (r *runner) randFloat64() float64 {
+	if r.attrRandom != nil {
+		return r.attrRandom.Float64()
+	}
+	if r.config.opts.perBulletRandom && r.host == nil {
+		return r.random.Float64()
+	}
+	return r.config.opts.Random.Float64()
+}
+
+// childSeed returns the seed of the random generator of a bullet fired by this runner.
+func (r *runner) childSeed() uint64 {
+	if r.host != nil {
+		return r.config.opts.Random.Uint64()
+	}
+	return r.random.Uint64()
+}
+
+// recordChange tells Recorder that the host changed the bullet.
+func (r *runner) recordChange(op changeOp, a, b float64) {
+	if f := r.config.opts.onChange; f != nil {
+		f(r, op, a, b)
+	}
+}
+
+// deferring returns whether Manager is updating the bullets in parallel.
+func (r *runner) deferring() bool {
+	m := r.config.opts.manager
+	return m != nil && m.deferring
+}
+
+// self returns the runner passed to the host for this runner.
+func (r *runner) self() Runner {
+	if r.host != nil {
+		return r.host
+	}
+	return r
+}
+
+func (r *runner) Direction() float64 {
+	return r.bullet.direction*180/math.Pi + 90
+}
+
+func (r *runner) DirectionRadians() float64 {
+	return r.bullet.direction
+}
+
+func (r *runner) Speed() float64 {
+	return r.bullet.speed
+}
+
+func (r *runner) Acceleration() (float64, float64) {
+	return r.bullet.accelSpeedHorizontal, r.bullet.accelSpeedVertical
+}
+
+func (r *runner) PolarAcceleration() (float64, float64) {
+	return r.bullet.accelSpeedTangential, r.bullet.accelSpeedNormal
+}
+
+func (r *runner) Velocity() (float64, float64) {
+	return r.velocity()
+}
+
+func (r *runner) SetDirection(dir float64) {
+	r.SetDirectionRadians((dir - 90) * math.Pi / 180)
+}
+
+func (r *runner) SetDirectionRadians(dir float64) {
+	if math.IsNaN(dir) || math.IsInf(dir, 0) {
+		return
+	}
+	r.recordChange(changeDirection, dir, 0)
+	r.setDirection(dir)
+}
+
+func (r *runner) setDirection(dir float64) {
+	r.unpack()
+	r.bullet.direction = normalizeDir(dir)
+	r.changeDirectionUntil = -1
+	r.homingUntil = -1
+	r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
+}
+
+func (r *runner) SetSpeed(speed float64) {
+	r.recordChange(changeSpeed, speed, 0)
+	r.setSpeed(speed)
+}
+
+func (r *runner) setSpeed(speed float64) {
+	r.unpack()
+	r.bullet.speed = speed
+	r.changeSpeedUntil = -1
+	r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
+}
+
+func (r *runner) SetAcceleration(horizontal, vertical float64) {
+	r.recordChange(changeAcceleration, horizontal, vertical)
+	r.unpack()
+	r.bullet.accelSpeedHorizontal = horizontal
+	r.bullet.accelSpeedVertical = vertical
+	r.accelUntil = -1
+	r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
+}
+
+func (r *runner) SetPolarAcceleration(tangential, normal float64) {
+	r.recordChange(changePolarAcceleration, tangential, normal)
+	r.unpack()
+	r.bullet.accelSpeedTangential = tangential
+	r.bullet.accelSpeedNormal = normal
+	r.accelUntil = -1
+	r.bulletVxCache, r.bulletVyCache = math.NaN(), math.NaN()
+}
+
+func (r *runner) SetVelocity(vx, vy float64) {
+	r.recordChange(changeVelocity, vx, vy)
+	b := r.bullet
+	vx -= b.accelSpeedHorizontal + b.fieldVx
+	vy -= b.accelSpeedVertical + b.fieldVy
+
+	if t, n := b.accelSpeedTangential, b.accelSpeedNormal; t != 0 || n != 0 {
+		// The speed along the direction is the rest of the velocity after the normal speed,
+		// and the direction is turned back by the angle of the normal speed
+		along := math.Sqrt(math.Max(float64(vx*vx)+float64(vy*vy)-float64(n*n), 0))
+		r.setSpeed(along - t)
+		r.setDirection(r.config.math.atan2(vy, vx) - r.config.math.atan2(n, along))
+		return
+	}
+
+	r.setSpeed(math.Sqrt(float64(vx*vx) + float64(vy*vy)))
+	if vx != 0 || vy != 0 {
+		r.setDirection(r.config.math.atan2(vy, vx))
+	} else {
+		r.changeDirectionUntil = -1
+		r.homingUntil = -1
+	}
+}
+
+func (r *runner) Parent() Runner {
+	return r.parent
+}
+
+func (r *runner) adopt(child *runner) {
+	if r.host != nil {
+		child.parent = r.host
+		r.host.add(child)
+	} else {
+		child.parent = r
+		r.add(child)
+	}
+}
+
+// family holds the bullet runners fired by a runner.
+type family struct {
+	children []*runner
+}
+
+func (f *family) add(child *runner) {
+	f.children = append(f.children, child)
+}
+
+// compact forgets vanished children. Vanished children are kept while they
+// have descendants alive so that the descendants remain reachable.
+// Released children are recycled when they are forgotten.
+func (f *family) compact() bool {
+	_children := f.children[:0]
+	for _, c := range f.children {
+		if !c.bullet.vanished || c.compact() {
+			_children = append(_children, c)
+		} else if c.released && !c.pinned() {
+			c.recycle()
+		}
+	}
+	for i := len(_children); i < len(f.children); i++ {
+		f.children[i] = nil
+	}
+	f.children = _children
+
+	return len(f.children) > 0
+}
+
+func (f *family) Children() []BulletRunner {
+	var children []BulletRunner
+	for _, c := range f.children {
+		if !c.bullet.vanished {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+func (f *family) WalkDescendants(fn func(BulletRunner) bool) {
+	f.walk(func(r *runner) bool {
+		return fn(r)
+	})
+}
+
+func (f *family) walk(fn func(*runner) bool) bool {
+	for _, c := range f.children {
+		if !c.bullet.vanished && !fn(c) {
+			return false
+		}
+		if !c.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *family) VanishDescendants() {
+	f.walk(func(r *runner) bool {
+		r.Vanish()
+		return true
+	})
+}
+
 type parameters map[string]float64
 
 type actionProcess struct {
@@ -372,33 +1167,99 @@ type actionProcess struct {
 	repeatActionCache        *Action
 	repeatParamsCache        parameters
 	params                   parameters
+	ownsParams               bool
 	runner                   *runner
 }
 
+// release releases the parameters owned by the process.
+func (p *actionProcess) release() {
+	pool := p.runner.config.pool
+	if p.ownsParams {
+		pool.releaseParameters(p.params)
+	}
+	pool.releaseParameters(p.repeatParamsCache)
+	*p = actionProcess{}
+}
+
 var (
 	actionProcessEnd  = errors.New("actionProcessEnd")
 	actionProcessWait = errors.New("actionProcessWait")
 )
 
-func updateBulletPosition(r *runner) {
+func updateBulletPosition(r *runner, step float64) {
 	if !r.bullet.vanished {
-		var vx, vy float64
-		if math.IsNaN(r.bulletVxCache) || math.IsNaN(r.bulletVyCache) {
-			vx = r.bullet.speed*math.Cos(r.bullet.direction) + r.bullet.accelSpeedHorizontal
-			vy = r.bullet.speed*math.Sin(r.bullet.direction) + r.bullet.accelSpeedVertical
-			r.bulletVxCache = vx
-			r.bulletVyCache = vy
-		} else {
-			vx = r.bulletVxCache
-			vy = r.bulletVyCache
+		if m := r.config.opts.manager; m != nil && len(m.fields) > 0 && r.host == nil {
+			r.applyFields(m.fields, step)
+		}
+		vx, vy := r.velocity()
+		r.bullet.x += float64(vx * step)
+		r.bullet.y += float64(vy * step)
+	}
+}
+
+// updateShooterPosition moves the shooter shared by the top-level runners once per tick.
+func updateShooterPosition(r *runner, step float64) {
+	if r != r.host.mover {
+		return
+	}
+
+	// The other runners may have changed the shooter
+	r.bulletVxCache = math.NaN()
+	updateBulletPosition(r, step)
+}
+
+// offset returns the amount by which <offset> moves the bullet fired in the direction.
+func (r *runner) offset(o *Offset, params parameters, dir float64) (float64, float64, error) {
+	var x, y float64
+	var err error
+	if e, exists := o.X.Get(); exists {
+		x, _, err = evaluateExpr(e.compiledExpr, params, e, r)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if e, exists := o.Y.Get(); exists {
+		y, _, err = evaluateExpr(e.compiledExpr, params, e, r)
+		if err != nil {
+			return 0, 0, err
 		}
+	}
+
+	if o.Type == OffsetTypeRelative {
+		cos, sin := r.config.math.cos(dir), r.config.math.sin(dir)
+		x, y = float64(x*cos)-float64(y*sin), float64(x*sin)+float64(y*cos)
+	}
+
+	return x, y, nil
+}
 
-		r.bullet.x += vx
-		r.bullet.y += vy
+// velocity returns the amount of the bullet movement per tick.
+func (r *runner) velocity() (float64, float64) {
+	if math.IsNaN(r.bulletVxCache) || math.IsNaN(r.bulletVyCache) {
+		m := r.config.math
+		b := r.bullet
+		cos, sin := m.cos(b.direction), m.sin(b.direction)
+		r.bulletVxCache = float64(b.speed*cos) + b.accelSpeedHorizontal
+		r.bulletVyCache = float64(b.speed*sin) + b.accelSpeedVertical
+		if b.accelSpeedTangential != 0 || b.accelSpeedNormal != 0 {
+			r.bulletVxCache += float64(b.accelSpeedTangential*cos) - float64(b.accelSpeedNormal*sin)
+			r.bulletVyCache += float64(b.accelSpeedTangential*sin) + float64(b.accelSpeedNormal*cos)
+		}
+		if b.fieldVx != 0 || b.fieldVy != 0 {
+			r.bulletVxCache += b.fieldVx
+			r.bulletVyCache += b.fieldVy
+		}
 	}
+	return r.bulletVxCache, r.bulletVyCache
 }
 
 func (p *actionProcess) update() error {
+	if p.actionIndex == 0 && p.repeatIndex == 0 {
+		if f := p.runner.config.opts.OnActionEnter; f != nil {
+			f(p.runner.self(), p.action)
+		}
+	}
+
 	for p.actionIndex < len(p.action.Commands) {
 		switch c := p.action.Commands[p.actionIndex].(type) {
 		case *Repeat:
@@ -416,16 +1277,22 @@ func (p *actionProcess) update() error {
 				action = p.repeatActionCache
 				params = p.repeatParamsCache
 			} else {
-				ac, prms, deterministic, err := p.runner.lookUpActionDefTable(coalesce(c.Action, c.ActionRef).(node), p.params)
+				ac, prms, deterministic, err := p.runner.lookUpActionDefTable(coalesce(c.Action, c.ActionRef), p.params)
 				if err != nil {
 					return err
 				}
 
 				action = ac
 
-				params = make(parameters)
-				for k, v := range prms {
-					params[k] = v
+				// Parameters of <actionRef> are created for this repeat,
+				// but those of inline <action> belong to this process
+				if _, isRef := c.ActionRef.Get(); isRef && prms != nil {
+					params = prms
+				} else {
+					params = p.runner.config.pool.newParameters()
+					for k, v := range prms {
+						params[k] = v
+					}
 				}
 
 				if deterministic {
@@ -437,42 +1304,53 @@ func (p *actionProcess) update() error {
 			if p.repeatIndex < p.repeatCount {
 				params["$loop.index"] = float64(p.repeatIndex)
 
-				p.runner.pushStack(action, params)
+				p.runner.pushStack(action, params, p.repeatActionCache == nil)
 
 				p.repeatIndex++
 
 				return nil
 			} else {
+				p.runner.config.pool.releaseParameters(params)
+
 				p.repeatIndex = 0
 				p.repeatCount = 0
 				p.repeatActionCache = nil
 				p.repeatParamsCache = nil
 			}
 		case *Fire, *FireRef:
-			fire, params, _, err := p.runner.lookUpFireDefTable(c.(node), p.params)
+			fire, params, _, err := p.runner.lookUpFireDefTable(c, p.params)
 			if err != nil {
 				return err
 			}
 			fireParams := params
 
-			bullet, params, _, err := p.runner.lookUpBulletDefTable(coalesce(fire.Bullet, fire.BulletRef).(node), params)
+			bullet, params, _, err := p.runner.lookUpBulletDefTable(coalesce(fire.Bullet, fire.BulletRef), params)
 			if err != nil {
 				return err
 			}
 			bulletParams := params
 
-			sx, sy := p.runner.bullet.x, p.runner.bullet.y
-			tx, ty := p.runner.config.opts.CurrentTargetPosition()
+			emitterName, emitter := p.emitter(fire)
+			sx, sy, baseDir := p.origin(emitter)
+
+			p.runner.selectTarget(sx, sy)
 
 			var dir float64
+			var dirParams parameters
+
+			// aimed is set if the direction is aimed at (tx, ty) with the angle aimAngle,
+			// and predicted is set if it leads the target moving at (tvx, tvy)
+			var aimed, predicted bool
+			var aimAngle, tx, ty, tvx, tvy float64
+
 			d, exists := fire.Direction.Get()
 			if exists {
-				dir, _, err = evaluateExpr(d.compiledExpr, fireParams, d, p.runner)
-				if err != nil {
-					return err
-				}
+				dirParams = fireParams
 			} else if d, exists = bullet.Direction.Get(); exists {
-				dir, _, err = evaluateExpr(d.compiledExpr, bulletParams, d, p.runner)
+				dirParams = bulletParams
+			}
+			if exists {
+				dir, _, err = evaluateExpr(d.compiledExpr, dirParams, d, p.runner)
 				if err != nil {
 					return err
 				}
@@ -482,19 +1360,32 @@ func (p *actionProcess) update() error {
 				dir = dir * math.Pi / 180
 
 				switch d.Type {
-				case DirectionTypeAim:
-					dir += math.Atan2(ty-sy, tx-sx)
+				case DirectionTypeAim, DirectionTypePredict:
+					index, err := p.runner.aimIndex(d, dirParams)
+					if err != nil {
+						return err
+					}
+					tx, ty = p.runner.targetPosition(index)
+					if d.Type == DirectionTypePredict {
+						// It is solved after the speed is evaluated
+						tvx, tvy = p.runner.targetVelocity(index)
+						predicted = true
+					}
+					aimed, aimAngle = true, dir
+					dir += p.runner.config.math.atan2(ty-sy, tx-sx)
 				case DirectionTypeAbsolute:
 					dir -= math.Pi / 2
 				case DirectionTypeRelative:
-					dir += p.runner.bullet.direction
+					dir += baseDir
 				case DirectionTypeSequence:
 					dir += p.runner.lastFireDirection
 				default:
 					return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", d.Type, d.XMLName.Local), d)
 				}
 			} else {
-				dir = math.Atan2(ty-sy, tx-sx)
+				tx, ty = p.runner.targetPosition(p.runner.useTarget())
+				aimed = true
+				dir = p.runner.config.math.atan2(ty-sy, tx-sx)
 			}
 
 			var speed float64
@@ -526,32 +1417,83 @@ func (p *actionProcess) update() error {
 				speed = p.runner.config.opts.DefaultBulletSpeed
 			}
 
-			config := *p.runner.config
-			config.updateBulletPosition = updateBulletPosition
-			bm := bulletModel{
-				x:         sx,
-				y:         sy,
-				speed:     speed,
-				direction: dir,
+			if predicted {
+				dir = aimAngle + p.runner.leadAngle(sx, sy, tx, ty, tvx, tvy, speed)
+			}
+
+			if o, exists := fire.Offset.Get(); exists {
+				ox, oy, err := p.runner.offset(o, fireParams, dir)
+				if err != nil {
+					return err
+				}
+				sx, sy = sx+ox, sy+oy
+
+				if o.Reaim && aimed {
+					dir = aimAngle + p.runner.leadAngle(sx, sy, tx, ty, tvx, tvy, speed)
+				}
+			}
+
+			// Attributes evaluated by the host later don't take turns
+			p.runner.targetPending = false
+
+			bulletRunner := newBulletRunner(p.runner.config.bulletConfig)
+			if p.runner.config.opts.perBulletRandom {
+				bulletRunner.random.state = p.runner.childSeed()
+			}
+			bulletRunner.target = p.runner.target
+			bulletRunner.spawnDelay = p.runner.elapsed
+			bm := bulletRunner.bullet
+			bm.x, bm.y = sx, sy
+			bm.speed, bm.direction = speed, dir
+			p.runner.adopt(bulletRunner)
+
+			// Actions may resume later than the exact time of a fractional wait,
+			// so move the bullet forward by the time it should have flown.
+			if p.runner.waitCarry > 0 {
+				vx, vy := bulletRunner.velocity()
+				bm.x += float64(vx * p.runner.waitCarry)
+				bm.y += float64(vy * p.runner.waitCarry)
 			}
-			bulletRunner := createRunner(&config, &bm)
 
 			for i := len(bullet.ActionOrRefs) - 1; i >= 0; i-- {
-				action, actionParams, _, err := p.runner.lookUpActionDefTable(bullet.ActionOrRefs[i].(node), params)
+				ac := bullet.ActionOrRefs[i]
+				action, actionParams, _, err := p.runner.lookUpActionDefTable(ac, bulletParams)
 				if err != nil {
 					return err
 				}
 
-				bulletRunner.pushStack(action, actionParams)
+				// The bullet gets its own copy of the parameters of inline <action>
+				// because they belong to this process
+				if _, isRef := ac.(*ActionRef); !isRef && actionParams != nil {
+					actionParams = p.runner.config.pool.copyParameters(actionParams)
+				}
+
+				bulletRunner.pushStack(action, actionParams, true)
 			}
 
-			p.runner.config.opts.OnBulletFired(bulletRunner, &FireContext{
-				Fire:   fire,
-				Bullet: bullet,
-			})
+			p.runner.lastFireDirection = bm.direction
+			p.runner.lastFireSpeed = bm.speed
+
+			bulletRunner.fireContext = FireContext{
+				Fire:         fire,
+				Bullet:       bullet,
+				Emitter:      emitterName,
+				runner:       p.runner,
+				fireParams:   fireParams,
+				bulletParams: bulletParams,
+			}
+			if bulletRunner.config.opts.perBulletRandom {
+				bulletRunner.fireContext.random = &bulletRunner.random
+			}
+			p.runner.config.opts.OnBulletFired(bulletRunner, &bulletRunner.fireContext)
+			bulletRunner.fireContext.fireParams, bulletRunner.fireContext.bulletParams = nil, nil
 
-			p.runner.lastFireDirection = bulletRunner.bullet.direction
-			p.runner.lastFireSpeed = bulletRunner.bullet.speed
+			if _, isRef := c.(*FireRef); isRef {
+				p.runner.config.pool.releaseParameters(fireParams)
+			}
+			if _, isRef := fire.BulletRef.Get(); isRef {
+				p.runner.config.pool.releaseParameters(bulletParams)
+			}
 		case *ChangeSpeed:
 			term, _, err := evaluateExpr(c.Term.compiledExpr, p.params, c.Term, p.runner)
 			if err != nil {
@@ -568,22 +1510,32 @@ func (p *actionProcess) update() error {
 				if c.Speed.Type == SpeedTypeRelative {
 					speed += p.runner.bullet.speed
 				}
-				p.runner.changeSpeedDelta = (speed - p.runner.bullet.speed) / term
+				p.runner.changeSpeedDelta = termDelta(speed-p.runner.bullet.speed, term)
 				p.runner.changeSpeedTarget = speed
 			case SpeedTypeSequence:
 				p.runner.changeSpeedDelta = speed
-				p.runner.changeSpeedTarget = speed*term + p.runner.bullet.speed
+				p.runner.changeSpeedTarget = float64(speed*math.Max(term, 0)) + p.runner.bullet.speed
 			default:
 				return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", c.Speed.Type, c.Speed.XMLName.Local), c.Speed)
 			}
 
-			p.runner.changeSpeedUntil = p.runner.ticks + int(term)
+			p.runner.changeSpeedUntil = p.runner.changeUntil(term)
+
+			e := &p.runner.changeSpeedEasing
+			e.start(c.Ease, c.easing, p.runner.ticks, p.runner.changeSpeedUntil, term)
+			e.from[0], e.span[0] = p.runner.bullet.speed, p.runner.changeSpeedTarget-p.runner.bullet.speed
+
+			if f := p.runner.config.opts.OnChangeSpeed; f != nil {
+				f(p.runner.self(), c)
+			}
 		case *ChangeDirection:
 			term, _, err := evaluateExpr(c.Term.compiledExpr, p.params, c.Term, p.runner)
 			if err != nil {
 				return err
 			}
 
+			p.runner.selectTarget(p.runner.bullet.x, p.runner.bullet.y)
+
 			dir, _, err := evaluateExpr(c.Direction.compiledExpr, p.params, c.Direction, p.runner)
 			if err != nil {
 				return err
@@ -591,35 +1543,67 @@ func (p *actionProcess) update() error {
 
 			dir = dir * math.Pi / 180
 
+			// span is the whole change of the direction, which may be over a turn
+			var span float64
+
 			switch c.Direction.Type {
-			case DirectionTypeAbsolute, DirectionTypeAim, DirectionTypeRelative:
+			case DirectionTypeAbsolute, DirectionTypeAim, DirectionTypePredict, DirectionTypeRelative:
 				if c.Direction.Type == DirectionTypeAbsolute {
 					dir -= math.Pi / 2
-				} else if c.Direction.Type == DirectionTypeAim {
+				} else if c.Direction.Type == DirectionTypeAim || c.Direction.Type == DirectionTypePredict {
+					index, err := p.runner.aimIndex(c.Direction, p.params)
+					if err != nil {
+						return err
+					}
 					sx, sy := p.runner.bullet.x, p.runner.bullet.y
-					tx, ty := p.runner.config.opts.CurrentTargetPosition()
-					dir += math.Atan2(ty-sy, tx-sx)
+					tx, ty := p.runner.targetPosition(index)
+					var tvx, tvy float64
+					if c.Direction.Type == DirectionTypePredict {
+						tvx, tvy = p.runner.targetVelocity(index)
+					}
+					dir += p.runner.leadAngle(sx, sy, tx, ty, tvx, tvy, p.runner.bullet.speed)
 				} else if c.Direction.Type == DirectionTypeRelative {
 					dir += p.runner.bullet.direction
 				}
 
-				p.runner.changeDirectionDelta = normalizeDir(dir-p.runner.bullet.direction) / term
+				span = normalizeDir(dir - p.runner.bullet.direction)
+				if c.Rotate != RotateTypeShortest {
+					if c.Direction.Type == DirectionTypeRelative {
+						span = dir - p.runner.bullet.direction
+					}
+					span = rotateSpan(span, c.Rotate)
+				}
+
+				p.runner.changeDirectionDelta = termDelta(span, term)
 				p.runner.changeDirectionTarget = normalizeDir(dir)
 			case DirectionTypeSequence:
 				p.runner.changeDirectionDelta = normalizeDir(dir)
-				p.runner.changeDirectionTarget = normalizeDir(dir*term + p.runner.bullet.direction)
+				p.runner.changeDirectionTarget = normalizeDir(float64(dir*math.Max(term, 0)) + p.runner.bullet.direction)
+				span = normalizeDir(dir) * math.Max(term, 0)
 			default:
 				return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", c.Direction.Type, c.Direction.XMLName.Local), c.Direction)
 			}
 
-			p.runner.changeDirectionUntil = p.runner.ticks + int(term)
+			// Later commands don't take turns with this choice
+			p.runner.targetPending = false
+
+			p.runner.changeDirectionUntil = p.runner.changeUntil(term)
+
+			e := &p.runner.changeDirectionEasing
+			e.start(c.Ease, c.easing, p.runner.ticks, p.runner.changeDirectionUntil, term)
+			e.from[0], e.span[0] = p.runner.bullet.direction, span
+			p.runner.homingUntil = -1
+
+			if f := p.runner.config.opts.OnChangeDirection; f != nil {
+				f(p.runner.self(), c)
+			}
 		case *Accel:
 			term, _, err := evaluateExpr(c.Term.compiledExpr, p.params, c.Term, p.runner)
 			if err != nil {
 				return err
 			}
 
-			p.runner.accelUntil = p.runner.ticks + int(term)
+			p.runner.accelUntil = p.runner.changeUntil(term)
 
 			if h, exists := c.Horizontal.Get(); exists {
 				horizontal, _, err := evaluateExpr(h.compiledExpr, p.params, h, p.runner)
@@ -632,11 +1616,11 @@ func (p *actionProcess) update() error {
 					if h.Type == HorizontalTypeRelative {
 						horizontal += p.runner.bullet.accelSpeedHorizontal
 					}
-					p.runner.accelHorizontalDelta = (horizontal - p.runner.bullet.accelSpeedHorizontal) / term
+					p.runner.accelHorizontalDelta = termDelta(horizontal-p.runner.bullet.accelSpeedHorizontal, term)
 					p.runner.accelHorizontalTarget = horizontal
 				case HorizontalTypeSequence:
 					p.runner.accelHorizontalDelta = horizontal
-					p.runner.accelHorizontalTarget = p.runner.bullet.accelSpeedHorizontal + horizontal*term
+					p.runner.accelHorizontalTarget = p.runner.bullet.accelSpeedHorizontal + float64(horizontal*math.Max(term, 0))
 				default:
 					return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", string(h.Type), h.XMLName.Local), h)
 				}
@@ -656,11 +1640,11 @@ func (p *actionProcess) update() error {
 					if v.Type == VerticalTypeRelative {
 						vertical += p.runner.bullet.accelSpeedVertical
 					}
-					p.runner.accelVerticalDelta = (vertical - p.runner.bullet.accelSpeedVertical) / term
+					p.runner.accelVerticalDelta = termDelta(vertical-p.runner.bullet.accelSpeedVertical, term)
 					p.runner.accelVerticalTarget = vertical
 				case VerticalTypeSequence:
 					p.runner.accelVerticalDelta = vertical
-					p.runner.accelVerticalTarget = p.runner.bullet.accelSpeedVertical + vertical*term
+					p.runner.accelVerticalTarget = p.runner.bullet.accelSpeedVertical + float64(vertical*math.Max(term, 0))
 				default:
 					return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", string(v.Type), v.XMLName.Local), v)
 				}
@@ -668,26 +1652,159 @@ func (p *actionProcess) update() error {
 				p.runner.accelVerticalDelta = 0
 				p.runner.accelVerticalTarget = p.runner.bullet.accelSpeedVertical
 			}
+
+			if t, exists := c.Tangential.Get(); exists {
+				tangential, _, err := evaluateExpr(t.compiledExpr, p.params, t, p.runner)
+				if err != nil {
+					return err
+				}
+
+				switch t.Type {
+				case TangentialTypeAbsolute, TangentialTypeRelative:
+					if t.Type == TangentialTypeRelative {
+						tangential += p.runner.bullet.accelSpeedTangential
+					}
+					p.runner.accelTangentialDelta = termDelta(tangential-p.runner.bullet.accelSpeedTangential, term)
+					p.runner.accelTangentialTarget = tangential
+				case TangentialTypeSequence:
+					p.runner.accelTangentialDelta = tangential
+					p.runner.accelTangentialTarget = p.runner.bullet.accelSpeedTangential + float64(tangential*math.Max(term, 0))
+				default:
+					return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", string(t.Type), t.XMLName.Local), t)
+				}
+			} else {
+				p.runner.accelTangentialDelta = 0
+				p.runner.accelTangentialTarget = p.runner.bullet.accelSpeedTangential
+			}
+
+			if n, exists := c.Normal.Get(); exists {
+				normal, _, err := evaluateExpr(n.compiledExpr, p.params, n, p.runner)
+				if err != nil {
+					return err
+				}
+
+				switch n.Type {
+				case NormalTypeAbsolute, NormalTypeRelative:
+					if n.Type == NormalTypeRelative {
+						normal += p.runner.bullet.accelSpeedNormal
+					}
+					p.runner.accelNormalDelta = termDelta(normal-p.runner.bullet.accelSpeedNormal, term)
+					p.runner.accelNormalTarget = normal
+				case NormalTypeSequence:
+					p.runner.accelNormalDelta = normal
+					p.runner.accelNormalTarget = p.runner.bullet.accelSpeedNormal + float64(normal*math.Max(term, 0))
+				default:
+					return newBulletmlError(fmt.Sprintf("Invalid type '%s' for <%s> element", string(n.Type), n.XMLName.Local), n)
+				}
+			} else {
+				p.runner.accelNormalDelta = 0
+				p.runner.accelNormalTarget = p.runner.bullet.accelSpeedNormal
+			}
+
+			e := &p.runner.accelEasing
+			e.start(c.Ease, c.easing, p.runner.ticks, p.runner.accelUntil, term)
+			b := p.runner.bullet
+			e.from = [4]float64{b.accelSpeedHorizontal, b.accelSpeedVertical, b.accelSpeedTangential, b.accelSpeedNormal}
+			e.span = [4]float64{
+				p.runner.accelHorizontalTarget - b.accelSpeedHorizontal,
+				p.runner.accelVerticalTarget - b.accelSpeedVertical,
+				p.runner.accelTangentialTarget - b.accelSpeedTangential,
+				p.runner.accelNormalTarget - b.accelSpeedNormal,
+			}
+
+			if f := p.runner.config.opts.OnAccel; f != nil {
+				f(p.runner.self(), c)
+			}
+		case *Homing:
+			term, _, err := evaluateExpr(c.Term.compiledExpr, p.params, c.Term, p.runner)
+			if err != nil {
+				return err
+			}
+
+			turnRate, _, err := evaluateExpr(c.TurnRate.compiledExpr, p.params, c.TurnRate, p.runner)
+			if err != nil {
+				return err
+			}
+
+			var acceleration float64
+			if a, exists := c.Acceleration.Get(); exists {
+				acceleration, _, err = evaluateExpr(a.compiledExpr, p.params, a, p.runner)
+				if err != nil {
+					return err
+				}
+			}
+
+			p.runner.selectTarget(p.runner.bullet.x, p.runner.bullet.y)
+			p.runner.useTarget()
+
+			// Homing takes over the direction from <changeDirection> in progress
+			p.runner.changeDirectionUntil = -1
+			p.runner.homingUntil = p.runner.changeUntil(term)
+			p.runner.homingTurnRate = math.Abs(turnRate) * math.Pi / 180
+			p.runner.homingAcceleration = acceleration
+			p.runner.homingFraction = 0
+			if term > 0 {
+				p.runner.homingFraction = term - math.Floor(term)
+			}
+
+			if f := p.runner.config.opts.OnHoming; f != nil {
+				f(p.runner.self(), c)
+			}
 		case *Wait:
 			wait, _, err := evaluateExpr(c.compiledExpr, p.params, c, p.runner)
 			if err != nil {
 				return err
 			}
 
-			p.runner.waitUntil = p.runner.ticks + int(wait)
+			p.runner.wait(wait)
+
+			if f := p.runner.config.opts.OnWaitStart; f != nil {
+				f(p.runner.self(), c)
+			}
 
 			p.actionIndex++
 
 			return actionProcessWait
 		case *Vanish:
-			p.runner.bullet.vanished = true
+			if !p.runner.bullet.vanished {
+				p.runner.bullet.vanished = true
+				if f := p.runner.config.opts.OnVanish; f != nil {
+					f(p.runner.self(), c)
+				}
+			}
+		case *CustomCommand:
+			ctx := &CustomCommandContext{
+				Runner:  p.runner.self(),
+				Command: c,
+				Params:  make(map[string]float64, len(c.compiledParams)),
+			}
+			for i, expr := range c.compiledParams {
+				v, _, err := evaluateExpr(expr, p.params, c, p.runner)
+				if err != nil {
+					return err
+				}
+				ctx.Params[c.spec.Params[i]] = v
+			}
+
+			if err := c.spec.Execute(ctx); err != nil {
+				return err
+			}
+
+			if ctx.waiting {
+				p.runner.wait(ctx.wait)
+
+				p.actionIndex++
+
+				return actionProcessWait
+			}
 		case *Action, *ActionRef:
-			action, params, _, err := p.runner.lookUpActionDefTable(c.(node), p.params)
+			action, params, _, err := p.runner.lookUpActionDefTable(c, p.params)
 			if err != nil {
 				return err
 			}
 
-			p.runner.pushStack(action, params)
+			_, isRef := c.(*ActionRef)
+			p.runner.pushStack(action, params, isRef)
 
 			p.actionIndex++
 
@@ -704,6 +1821,9 @@ func evaluateExpr(expr ast.Expr, params parameters, node node, runner *runner) (
 	switch e := expr.(type) {
 	case *numberValue:
 		return e.value, true, nil
+	case *targetValue:
+		v, err := evaluateTargetValue(e, params, node, runner)
+		return v, false, err
 	case *ast.BinaryExpr:
 		x, xDc, err := evaluateExpr(e.X, params, node, runner)
 		if err != nil {
@@ -719,7 +1839,7 @@ func evaluateExpr(expr ast.Expr, params parameters, node node, runner *runner) (
 		case token.SUB:
 			return x - y, xDc && yDc, nil
 		case token.MUL:
-			return x * y, xDc && yDc, nil
+			return float64(x * y), xDc && yDc, nil
 		case token.QUO:
 			return x / y, xDc && yDc, nil
 		case token.REM:
@@ -741,26 +1861,24 @@ func evaluateExpr(expr ast.Expr, params parameters, node node, runner *runner) (
 	case *ast.Ident:
 		switch e.Name {
 		case "$rand":
-			return runner.config.opts.Random.Float64(), false, nil
+			return runner.randFloat64(), false, nil
 		case "$rank":
 			return runner.config.opts.Rank, true, nil
 		case "$direction":
 			b := runner.bullet
-			if b.accelSpeedHorizontal == 0 && b.accelSpeedVertical == 0 {
+			if b.straight() {
 				return b.direction*180/math.Pi + 90, false, nil
 			} else {
-				vx := b.speed*math.Cos(b.direction) + b.accelSpeedHorizontal
-				vy := b.speed*math.Sin(b.direction) + b.accelSpeedVertical
-				return math.Atan2(vy, vx)*180/math.Pi + 90, false, nil
+				vx, vy := runner.velocity()
+				return runner.config.math.atan2(vy, vx)*180/math.Pi + 90, false, nil
 			}
 		case "$speed":
 			b := runner.bullet
-			if b.accelSpeedHorizontal == 0 && b.accelSpeedVertical == 0 {
+			if b.straight() {
 				return b.speed, false, nil
 			} else {
-				vx := b.speed*math.Cos(b.direction) + b.accelSpeedHorizontal
-				vy := b.speed*math.Sin(b.direction) + b.accelSpeedVertical
-				return math.Sqrt(vx*vx + vy*vy), false, nil
+				vx, vy := runner.velocity()
+				return math.Sqrt(float64(vx*vx) + float64(vy*vy)), false, nil
 			}
 		default:
 			if v, exists := params[e.Name]; exists {
@@ -796,13 +1914,13 @@ func evaluateExpr(expr ast.Expr, params parameters, node node, runner *runner) (
 				return 0, false, newBulletmlError(fmt.Sprintf("Too few arguments for sin(): %d", len(args)), node)
 			}
 			arg := args[0] * math.Pi / 180
-			return math.Sin(arg), dc, nil
+			return runner.config.math.sin(arg), dc, nil
 		case "cos":
 			if len(args) < 1 {
 				return 0, false, newBulletmlError(fmt.Sprintf("Too few arguments for cos(): %d", len(args)), node)
 			}
 			arg := args[0] * math.Pi / 180
-			return math.Cos(arg), dc, nil
+			return runner.config.math.cos(arg), dc, nil
 		default:
 			return 0, false, newBulletmlError(fmt.Sprintf("Unsupported function: %s", f.Name), node)
 		}
@@ -817,12 +1935,22 @@ func evaluateExpr(expr ast.Expr, params parameters, node node, runner *runner) (
 	}
 }
 
-func normalizeDir(dir float64) float64 {
-	for dir > math.Pi {
-		dir -= math.Pi * 2
-	}
-	for dir < -math.Pi {
-		dir += math.Pi * 2
+// rotateSpan turns the angle into the same direction going the way of the rotate type.
+func rotateSpan(span float64, rotate RotateType) float64 {
+	switch rotate {
+	case RotateTypeClockwise:
+		for span < 0 {
+			span += math.Pi * 2
+		}
+	case RotateTypeCounterClockwise:
+		for span > 0 {
+			span -= math.Pi * 2
+		}
 	}
-	return dir
+	return span
+}
+
+// normalizeDir wraps the angle into [-π, π]. Non-finite angles stay NaN.
+func normalizeDir(dir float64) float64 {
+	return math.Remainder(dir, math.Pi*2)
 }
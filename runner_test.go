@@ -0,0 +1,462 @@
+package bulletml
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func loadTestBulletML(t testing.TB, src string) *BulletML {
+	t.Helper()
+	bml, err := Load(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bml
+}
+
+func TestChildrenBoundedUnderCulling(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <repeat><times>10000</times><action>
+    <fire><direction type="sequence">7</direction><speed>3</speed><bullet/></fire>
+    <fire><direction type="sequence">7</direction><speed>2</speed>
+      <bullet><action><wait>5</wait>
+        <fire><direction type="sequence">30</direction><bullet/></fire>
+      </action></bullet>
+    </fire>
+    <wait>1</wait>
+  </action></repeat>
+</action>
+</bulletml>`
+
+	tests := []struct {
+		name string
+		cull func(BulletRunner)
+	}{
+		{"vanish", BulletRunner.Vanish},
+		{"release", BulletRunner.Release},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bullets []BulletRunner
+			r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+				OnBulletFired:         func(b BulletRunner, _ *FireContext) { bullets = append(bullets, b) },
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+				Seed:                  1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2000; i++ {
+				if err := r.Update(); err != nil {
+					t.Fatal(err)
+				}
+				for j, n := 0, len(bullets); j < n; j++ {
+					if err := bullets[j].Update(); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				_bullets := bullets[:0]
+				for _, b := range bullets {
+					if b.Vanished() {
+						continue
+					}
+					if x, y := b.Position(); x*x+y*y < 50*50 {
+						_bullets = append(_bullets, b)
+					} else {
+						tt.cull(b)
+					}
+				}
+				bullets = _bullets
+			}
+
+			// The runners forget the culled bullets in the next update
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range bullets {
+				if err := b.Update(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Vanished bullets are kept only while they have bullets alive
+			n := 0
+			r.(*multiRunner).family.each(func(c *runner) {
+				n++
+				// walk stops at the first descendant alive
+				if alive := !c.walk(func(*runner) bool { return false }); c.bullet.vanished && !alive {
+					t.Errorf("a vanished bullet without bullets alive is kept")
+				}
+			})
+			if n > 2*len(bullets) {
+				t.Errorf("runners keep %d bullets while the host keeps %d", n, len(bullets))
+			}
+		})
+	}
+}
+
+func TestSetDirectionOutOfRange(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <fire><direction type="absolute">90</direction><speed>1</speed><bullet/></fire>
+</action>
+</bulletml>`
+
+	var bullet BulletRunner
+	r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+		OnBulletFired:         func(b BulletRunner, _ *FireContext) { bullet = b },
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	huge := 1e300
+	tests := []struct {
+		name string
+		set  func()
+		want float64
+	}{
+		{"huge", func() { bullet.SetDirectionRadians(huge) }, math.Remainder(huge, 2*math.Pi)},
+		{"huge degrees", func() { bullet.SetDirection(huge) }, math.Remainder((huge-90)*math.Pi/180, 2*math.Pi)},
+		{"+Inf", func() { bullet.SetDirectionRadians(math.Inf(1)) }, 1},
+		{"-Inf", func() { bullet.SetDirection(math.Inf(-1)) }, 1},
+		{"NaN", func() { bullet.SetDirectionRadians(math.NaN()) }, 1},
+		{"turns", func() { bullet.SetDirectionRadians(3*math.Pi + 0.5) }, 0.5 - math.Pi},
+		{"negative turns", func() { bullet.SetDirectionRadians(-5 * math.Pi / 2) }, -math.Pi / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bullet.SetDirectionRadians(1)
+			tt.set()
+			if d := bullet.DirectionRadians(); math.Abs(d-tt.want) > 1e-12 {
+				t.Errorf("direction %v, want %v", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitRoundingError(t *testing.T) {
+	// $rank * 3 * 10 is 3.0000000000000004 for rank 0.1
+	const src = `<bulletml>
+<action label="top">
+  <repeat><times>3</times><action>
+    <fire><bullet/></fire>
+    <wait>$rank * 3 * 10</wait>
+  </action></repeat>
+</action>
+</bulletml>`
+
+	var ticks []int
+	tick := 0
+	r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+		OnBulletFired:         func(BulletRunner, *FireContext) { ticks = append(ticks, tick) },
+		CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+		CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+		Rank:                  0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ; tick < 10; tick++ {
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []int{0, 4, 8}
+	if len(ticks) != len(want) || ticks[0] != want[0] || ticks[1] != want[1] || ticks[2] != want[2] {
+		t.Errorf("fired at ticks %v, want %v", ticks, want)
+	}
+}
+
+func TestRoundRobinSkipsUnaimedCommands(t *testing.T) {
+	const src = `<bulletml>
+<action label="top">
+  <repeat><times>3</times><action>
+    <fire><direction type="aim">0</direction><bullet/></fire>
+    <fire><direction type="absolute">180</direction><bullet/></fire>
+    <changeDirection><direction type="absolute">90</direction><term>1</term></changeDirection>
+    <wait>1</wait>
+  </action></repeat>
+</action>
+</bulletml>`
+
+	targets := []Target{{X: -100, Y: 100}, {X: 0, Y: 100}, {X: 100, Y: 100}}
+
+	var bullets []BulletRunner
+	r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+		OnBulletFired:        func(b BulletRunner, _ *FireContext) { bullets = append(bullets, b) },
+		CurrentShootPosition: func() (float64, float64) { return 0, 0 },
+		Targets:              func() []Target { return targets },
+		TargetSelection:      TargetSelectionRoundRobin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(bullets) != 6 {
+		t.Fatalf("%d bullets are fired, want 6", len(bullets))
+	}
+	// The turn advances only for the aimed bullets
+	for i := 0; i < 3; i++ {
+		vx, vy := bullets[i*2].Velocity()
+		got, want := math.Atan2(vy, vx), math.Atan2(targets[i].Y, targets[i].X)
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("aimed bullet %d is fired at %v, want %v (target %d)", i, got, want, i+1)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		fire       string
+		x, y, dir  float64
+		wantsError bool
+	}{
+		{
+			name: "absolute",
+			fire: `<fire><direction type="absolute">90</direction><offset type="absolute"><x>10</x><y>-5</y></offset><bullet/></fire>`,
+			x:    10, y: -5, dir: 90,
+		},
+		{
+			name: "relative",
+			fire: `<fire><direction type="absolute">90</direction><offset type="relative"><x>10</x><y>5</y></offset><bullet/></fire>`,
+			x:    10, y: 5, dir: 90,
+		},
+		{
+			name: "relative by default",
+			fire: `<fire><direction type="absolute">180</direction><offset><x>10</x><y>5</y></offset><bullet/></fire>`,
+			x:    -5, y: 10, dir: 180,
+		},
+		{
+			name: "expression",
+			fire: `<fire><direction type="absolute">90</direction><offset><x>3 * 4</x></offset><bullet/></fire>`,
+			x:    12, y: 0, dir: 90,
+		},
+		{
+			name: "aim",
+			fire: `<fire><direction type="aim">0</direction><offset type="absolute"><x>100</x></offset><bullet/></fire>`,
+			x:    100, y: 0, dir: 180,
+		},
+		{
+			name: "reaim",
+			fire: `<fire><direction type="aim">0</direction><offset type="absolute" reaim="true"><x>100</x></offset><bullet/></fire>`,
+			x:    100, y: 0, dir: 225,
+		},
+		{
+			name: "reaim without aim",
+			fire: `<fire><direction type="absolute">90</direction><offset type="absolute" reaim="true"><x>100</x></offset><bullet/></fire>`,
+			x:    100, y: 0, dir: 90,
+		},
+		{
+			name:       "invalid type",
+			fire:       `<fire><offset type="polar"><x>1</x></offset><bullet/></fire>`,
+			wantsError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `<bulletml><action label="top">` + tt.fire + `</action></bulletml>`
+
+			var fired [][3]float64
+			r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+				OnBulletFired: func(b BulletRunner, _ *FireContext) {
+					x, y := b.Position()
+					fired = append(fired, [3]float64{x, y, b.Direction()})
+				},
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+			})
+			if tt.wantsError {
+				if err == nil {
+					t.Error("NewRunner succeeds, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+			if len(fired) != 1 {
+				t.Fatalf("%d bullets are fired, want 1", len(fired))
+			}
+
+			x, y, dir := fired[0][0], fired[0][1], fired[0][2]
+			if math.Abs(x-tt.x) > 1e-9 || math.Abs(y-tt.y) > 1e-9 {
+				t.Errorf("bullet is fired at (%v, %v), want (%v, %v)", x, y, tt.x, tt.y)
+			}
+			if d := math.Remainder(dir-tt.dir, 360); math.Abs(d) > 1e-9 {
+				t.Errorf("bullet is fired in direction %v, want %v", dir, tt.dir)
+			}
+		})
+	}
+}
+
+func TestHoming(t *testing.T) {
+	// The bullet moves to the right at speed 1 from the origin
+	tests := []struct {
+		name   string
+		homing string
+
+		// (tx, ty) is the target, and dir and speed are those after homing
+		tx, ty     float64
+		dir, speed float64
+	}{
+		{
+			name:   "turn rate",
+			homing: `<homing><term>2</term><turnRate>10</turnRate></homing>`,
+			tx:     0, ty: 1e9,
+			dir: 110, speed: 1,
+		},
+		{
+			name:   "fractional term",
+			homing: `<homing><term>2.5</term><turnRate>10</turnRate></homing>`,
+			tx:     0, ty: 1e9,
+			dir: 115, speed: 1,
+		},
+		{
+			name:   "short term",
+			homing: `<homing><term>0.5</term><turnRate>10</turnRate></homing>`,
+			tx:     0, ty: 1e9,
+			dir: 95, speed: 1,
+		},
+		{
+			name:   "acceleration ahead",
+			homing: `<homing><term>2</term><turnRate>0</turnRate><acceleration>0.5</acceleration></homing>`,
+			tx:     1e9, ty: 0,
+			dir: 90, speed: 2,
+		},
+		{
+			name:   "acceleration across",
+			homing: `<homing><term>1</term><turnRate>0</turnRate><acceleration>1</acceleration></homing>`,
+			tx:     0, ty: 1e9,
+			dir: 135, speed: math.Sqrt2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `<bulletml><action label="top">
+  <fire><direction type="absolute">90</direction><speed>1</speed><bullet><action>` + tt.homing + `</action></bullet></fire>
+</action></bulletml>`
+
+			var bullet BulletRunner
+			r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+				OnBulletFired:         func(b BulletRunner, _ *FireContext) { bullet = b },
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return tt.tx, tt.ty },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 5; i++ {
+				if err := r.Update(); err != nil {
+					t.Fatal(err)
+				}
+				if bullet != nil {
+					if err := bullet.Update(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if d := math.Remainder(bullet.Direction()-tt.dir, 360); math.Abs(d) > 1e-6 {
+				t.Errorf("direction is %v, want %v", bullet.Direction(), tt.dir)
+			}
+			if math.Abs(bullet.Speed()-tt.speed) > 1e-6 {
+				t.Errorf("speed is %v, want %v", bullet.Speed(), tt.speed)
+			}
+		})
+	}
+}
+
+func TestEasingFractionalTermGolden(t *testing.T) {
+	// The changes take 2.5 ticks, so the eased values are at 0.4 and 0.8 of the term
+	// on the first two ticks, and the changes end on the third tick.
+	tests := []struct {
+		name   string
+		change string
+		value  func(BulletRunner) float64
+		want   []float64
+	}{
+		{
+			name:   "speed linear",
+			change: `<changeSpeed ease="linear"><speed>6</speed><term>2.5</term></changeSpeed>`,
+			value:  BulletRunner.Speed,
+			want:   []float64{3.6, 5.2, 6, 6},
+		},
+		{
+			name:   "speed inQuad",
+			change: `<changeSpeed ease="inQuad"><speed>6</speed><term>2.5</term></changeSpeed>`,
+			value:  BulletRunner.Speed,
+			want:   []float64{2 + 4*0.16, 2 + 4*0.64, 6, 6},
+		},
+		{
+			name:   "direction linear",
+			change: `<changeDirection ease="linear"><direction type="absolute">180</direction><term>2.5</term></changeDirection>`,
+			value:  BulletRunner.Direction,
+			want:   []float64{126, 162, 180, 180},
+		},
+		{
+			name:   "accel outQuad",
+			change: `<accel ease="outQuad"><horizontal>1</horizontal><term>2.5</term></accel>`,
+			value: func(b BulletRunner) float64 {
+				ax, _ := b.Acceleration()
+				return ax
+			},
+			want: []float64{0.64, 0.96, 1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `<bulletml><action label="top">
+  <fire><direction type="absolute">90</direction><speed>2</speed><bullet><action>` + tt.change + `</action></bullet></fire>
+</action></bulletml>`
+
+			var bullet BulletRunner
+			r, err := NewRunner(loadTestBulletML(t, src), &NewRunnerOptions{
+				OnBulletFired:         func(b BulletRunner, _ *FireContext) { bullet = b },
+				CurrentShootPosition:  func() (float64, float64) { return 0, 0 },
+				CurrentTargetPosition: func() (float64, float64) { return 0, 100 },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range tt.want {
+				if err := bullet.Update(); err != nil {
+					t.Fatal(err)
+				}
+				if got := tt.value(bullet); math.Abs(got-want) > 1e-12 {
+					t.Errorf("value at tick %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -248,9 +248,13 @@ func (g *Game) Update() error {
 
 	_bullets := g.bullets[:0]
 	for _, b := range g.bullets {
-		if !b.runner.Vanished() &&
-			b.x > -screenWidth && b.x < screenWidth*2 && b.y > -screenHeight && b.y < screenHeight*2 {
+		if b.runner.Vanished() {
+			continue
+		}
+		if b.x > -screenWidth && b.x < screenWidth*2 && b.y > -screenHeight && b.y < screenHeight*2 {
 			_bullets = append(_bullets, b)
+		} else {
+			b.runner.Release()
 		}
 	}
 	g.bullets = _bullets
@@ -0,0 +1,214 @@
+package bulletml
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+)
+
+// Snapshot is a saved state of a runner and the bullets fired from it.
+// It is created by Runner.Snapshot and can be restored any number of times.
+// Released bullets in a snapshot are not reused for new bullets until the snapshot is garbage collected.
+type Snapshot struct {
+	owner     Runner
+	multi     *multiRunnerState
+	runners   []runnerState
+	random    uint64
+	hasRandom bool
+}
+
+type multiRunnerState struct {
+	runners  []*runner
+	children []*runner
+}
+
+type runnerState struct {
+	runner *runner
+	saved  runner
+	bullet bulletModel
+}
+
+// check returns an error if the snapshot can't be restored.
+func (s *Snapshot) check() error {
+	if !s.hasRandom {
+		return errors.New("Snapshot can't restore the state of NewRunnerOptions.Random; use NewRunnerOptions.Seed instead")
+	}
+	return nil
+}
+
+// pin keeps the runners in the snapshot out of the pool while the snapshot is alive.
+func (s *Snapshot) pin() {
+	for _, st := range s.runners {
+		atomic.AddInt32(st.runner.pins, 1)
+	}
+	runtime.SetFinalizer(s, (*Snapshot).unpin)
+}
+
+func (s *Snapshot) unpin() {
+	for _, st := range s.runners {
+		atomic.AddInt32(st.runner.pins, -1)
+	}
+}
+
+func (m *multiRunner) Snapshot() *Snapshot {
+	s := &Snapshot{
+		owner: m,
+		multi: &multiRunnerState{
+			runners:  cloneSlice(m.runners),
+			children: cloneSlice(m.children),
+		},
+	}
+
+	for _, r := range m.runners {
+		s.runners = append(s.runners, saveRunnerState(r))
+	}
+	m.family.each(func(r *runner) {
+		s.runners = append(s.runners, saveRunnerState(r))
+	})
+
+	s.saveRandom(m.config)
+	s.pin()
+
+	return s
+}
+
+func (m *multiRunner) Restore(s *Snapshot) error {
+	if s.owner != m {
+		return errors.New("Snapshot was taken from another runner")
+	}
+	if err := s.check(); err != nil {
+		return err
+	}
+
+	m.family.each(func(r *runner) {
+		r.markVanished()
+	})
+
+	m.runners = cloneSlice(s.multi.runners)
+	m.children = cloneSlice(s.multi.children)
+
+	s.restoreRunners()
+	s.restoreRandom(m.config)
+	if mgr := m.config.opts.manager; mgr != nil {
+		mgr.readd(m)
+	}
+
+	return nil
+}
+
+func (r *runner) Snapshot() *Snapshot {
+	s := &Snapshot{
+		owner:   r,
+		runners: []runnerState{saveRunnerState(r)},
+	}
+
+	r.family.each(func(r *runner) {
+		s.runners = append(s.runners, saveRunnerState(r))
+	})
+
+	s.saveRandom(r.config)
+	s.pin()
+
+	return s
+}
+
+func (r *runner) Restore(s *Snapshot) error {
+	if s.owner != r {
+		return errors.New("Snapshot was taken from another runner")
+	}
+	if err := s.check(); err != nil {
+		return err
+	}
+
+	r.family.each(func(r *runner) {
+		r.markVanished()
+	})
+
+	s.restoreRunners()
+	s.restoreRandom(r.config)
+	if m := r.config.opts.manager; m != nil {
+		m.readd(r)
+	}
+
+	return nil
+}
+
+func (s *Snapshot) saveRandom(config *runnerConfig) {
+	if config.randomSource != nil {
+		s.random = config.randomSource.state
+		s.hasRandom = true
+	}
+}
+
+func (s *Snapshot) restoreRandom(config *runnerConfig) {
+	if s.hasRandom {
+		config.randomSource.state = s.random
+	}
+}
+
+func (s *Snapshot) restoreRunners() {
+	for _, st := range s.runners {
+		st.runner.unpack()
+		managed := st.runner.managed
+		*st.runner = st.saved
+		st.runner.managed = managed
+		st.runner.children = cloneSlice(st.saved.children)
+		st.runner.stack = cloneStack(st.saved.stack)
+		*st.runner.bullet = st.bullet
+	}
+}
+
+func saveRunnerState(r *runner) runnerState {
+	r.sync()
+	if r.pins == nil {
+		r.pins = new(int32)
+	}
+
+	st := runnerState{
+		runner: r,
+		saved:  *r,
+		bullet: *r.bullet,
+	}
+	st.saved.children = cloneSlice(r.children)
+	st.saved.stack = cloneStack(r.stack)
+	st.saved.packed = nil
+
+	return st
+}
+
+// each calls f for all descendants including vanished ones.
+func (f *family) each(fn func(*runner)) {
+	for _, c := range f.children {
+		fn(c)
+		c.each(fn)
+	}
+}
+
+func cloneStack(stack []*actionProcess) []*actionProcess {
+	cloned := make([]*actionProcess, len(stack))
+	for i, p := range stack {
+		c := *p
+		c.params = cloneParameters(p.params)
+		c.repeatParamsCache = cloneParameters(p.repeatParamsCache)
+		cloned[i] = &c
+	}
+	return cloned
+}
+
+func cloneParameters(params parameters) parameters {
+	if params == nil {
+		return nil
+	}
+	cloned := make(parameters, len(params))
+	for k, v := range params {
+		cloned[k] = v
+	}
+	return cloned
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(s)), s...)
+}
@@ -0,0 +1,253 @@
+package bulletml
+
+import (
+	"fmt"
+	"go/ast"
+	"math"
+)
+
+// Target is a position which bullets can be aimed at, e.g. a player.
+type Target struct {
+	X, Y float64
+
+	// VX and VY are the velocity per tick, which <direction type="predict"> uses.
+	VX, VY float64
+}
+
+// TargetSelection is the policy to choose a target from NewRunnerOptions.Targets.
+type TargetSelection int
+
+const (
+	// TargetSelectionNearest chooses the target nearest to the shooter.
+	TargetSelectionNearest TargetSelection = iota
+
+	// TargetSelectionRoundRobin chooses the targets in turn. Each runner has its own turn,
+	// which advances only when the chosen target is aimed at or referred to by $target.
+	TargetSelectionRoundRobin
+
+	// TargetSelectionRandom chooses a target at random.
+	TargetSelectionRandom
+)
+
+// targetValue is a field of a target in expressions, e.g. $target.x or $target(2).x.
+type targetValue struct {
+	ast.Expr
+
+	// index is the one-based index of the target, or nil for the chosen target
+	index ast.Expr
+	field string
+}
+
+var targetFields = []string{"x", "y", "vx", "vy", "px", "py"}
+
+func compileTargetValue(e *ast.SelectorExpr, bmlNode node) (ast.Expr, error) {
+	v := &targetValue{field: e.Sel.Name}
+
+	switch x := e.X.(type) {
+	case *ast.Ident:
+		if x.Name != "V_target" {
+			return nil, newBulletmlError("Unsupported expression: target must be $target or $target(index)", bmlNode)
+		}
+	case *ast.CallExpr:
+		if f, ok := x.Fun.(*ast.Ident); !ok || f.Name != "V_target" || len(x.Args) != 1 {
+			return nil, newBulletmlError("Unsupported expression: target must be $target or $target(index)", bmlNode)
+		}
+		index, err := compileAst(x.Args[0], bmlNode)
+		if err != nil {
+			return nil, err
+		}
+		v.index = index
+	default:
+		return nil, newBulletmlError("Unsupported expression: target must be $target or $target(index)", bmlNode)
+	}
+
+	if !isIn(v.field, targetFields) {
+		return nil, newBulletmlError(fmt.Sprintf("Unsupported target field: %s", v.field), bmlNode)
+	}
+
+	return v, nil
+}
+
+func evaluateTargetValue(e *targetValue, params parameters, node node, runner *runner) (float64, error) {
+	var index int
+	if e.index != nil {
+		i, _, err := evaluateExpr(e.index, params, node, runner)
+		if err != nil {
+			return 0, err
+		}
+		index = int(i) - 1
+	} else {
+		index = runner.useTarget()
+	}
+
+	switch e.field {
+	case "x":
+		x, _ := runner.targetPosition(index)
+		return x, nil
+	case "y":
+		_, y := runner.targetPosition(index)
+		return y, nil
+	case "vx":
+		vx, _ := runner.targetVelocity(index)
+		return vx, nil
+	case "vy":
+		_, vy := runner.targetVelocity(index)
+		return vy, nil
+	}
+
+	// The predicted point is for the speed of the last fired bullet
+	speed := runner.lastFireSpeed
+	if speed == 0 {
+		speed = runner.config.opts.DefaultBulletSpeed
+	}
+	tx, ty := runner.targetPosition(index)
+	tvx, tvy := runner.targetVelocity(index)
+	px, py := interceptPoint(runner.bullet.x, runner.bullet.y, tx, ty, tvx, tvy, speed)
+	if e.field == "px" {
+		return px, nil
+	}
+	return py, nil
+}
+
+// selectTarget chooses the target of the runner by the selection policy.
+// It is called when <fire>, <changeDirection> and <homing> run at (x, y).
+func (r *runner) selectTarget(x, y float64) {
+	r.targetPending = false
+
+	opts := r.config.opts
+	if opts.Targets == nil {
+		return
+	}
+
+	targets := opts.Targets()
+	if len(targets) == 0 {
+		r.target = 0
+		return
+	}
+
+	switch opts.TargetSelection {
+	case TargetSelectionRoundRobin:
+		r.target = r.targetTurn % len(targets)
+		r.targetPending = true
+	case TargetSelectionRandom:
+		r.target = int(r.randFloat64() * float64(len(targets)))
+	default:
+		nearest := math.Inf(1)
+		for i, t := range targets {
+			if d := float64((t.X-x)*(t.X-x)) + float64((t.Y-y)*(t.Y-y)); d < nearest {
+				r.target, nearest = i, d
+			}
+		}
+	}
+}
+
+// useTarget returns the chosen target. The turn of TargetSelectionRoundRobin advances
+// when the choice is used for the first time, so commands which don't aim don't take turns.
+func (r *runner) useTarget() int {
+	if r.targetPending {
+		r.targetPending = false
+		r.targetTurn++
+	}
+	return r.target
+}
+
+// targetPosition returns the position of the target of the zero-based index.
+// Indices out of range wrap around.
+func (r *runner) targetPosition(index int) (float64, float64) {
+	opts := r.config.opts
+	if opts.Targets == nil {
+		return opts.CurrentTargetPosition()
+	}
+
+	if t, exists := r.findTarget(index); exists {
+		return t.X, t.Y
+	}
+	if opts.CurrentTargetPosition != nil {
+		return opts.CurrentTargetPosition()
+	}
+	return r.bullet.x, r.bullet.y
+}
+
+// targetVelocity returns the velocity of the target of the zero-based index.
+func (r *runner) targetVelocity(index int) (float64, float64) {
+	opts := r.config.opts
+	if opts.Targets != nil {
+		if t, exists := r.findTarget(index); exists {
+			return t.VX, t.VY
+		}
+	}
+	if opts.CurrentTargetVelocity != nil && (opts.Targets == nil || opts.CurrentTargetPosition != nil) {
+		return opts.CurrentTargetVelocity()
+	}
+	return 0, 0
+}
+
+func (r *runner) findTarget(index int) (Target, bool) {
+	targets := r.config.opts.Targets()
+	if len(targets) == 0 {
+		return Target{}, false
+	}
+
+	index %= len(targets)
+	if index < 0 {
+		index += len(targets)
+	}
+	return targets[index], true
+}
+
+// aimIndex returns the index of the target which <direction type="aim"> aims at.
+func (r *runner) aimIndex(d *Direction, params parameters) (int, error) {
+	if d == nil || d.compiledTarget == nil {
+		return r.useTarget(), nil
+	}
+
+	i, _, err := evaluateExpr(d.compiledTarget, params, d, r)
+	if err != nil {
+		return 0, err
+	}
+	return int(i) - 1, nil
+}
+
+// leadAngle returns the angle to fire a bullet from (sx, sy) at the speed
+// so that it hits the target moving at (tvx, tvy). It aims at the target if it is still.
+func (r *runner) leadAngle(sx, sy, tx, ty, tvx, tvy, speed float64) float64 {
+	if tvx != 0 || tvy != 0 {
+		tx, ty = interceptPoint(sx, sy, tx, ty, tvx, tvy, speed)
+	}
+	return r.config.math.atan2(ty-sy, tx-sx)
+}
+
+// interceptPoint returns the point where a bullet fired from (sx, sy) at the speed meets
+// the target moving from (tx, ty) at (tvx, tvy) per tick. It returns the current position
+// of the target if the bullet can't catch up with it.
+func interceptPoint(sx, sy, tx, ty, tvx, tvy, speed float64) (float64, float64) {
+	dx, dy := tx-sx, ty-sy
+
+	// Solve |d + v t| = speed t for the smallest t > 0
+	a := float64(tvx*tvx) + float64(tvy*tvy) - float64(speed*speed)
+	b := 2 * (float64(dx*tvx) + float64(dy*tvy))
+	c := float64(dx*dx) + float64(dy*dy)
+
+	t := -1.0
+	if math.Abs(a) < 1e-9 {
+		if b != 0 {
+			t = -c / b
+		}
+	} else if disc := float64(b*b) - float64(4*a*c); disc >= 0 {
+		sq := math.Sqrt(disc)
+		t1, t2 := (-b-sq)/(2*a), (-b+sq)/(2*a)
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		if t1 > 0 {
+			t = t1
+		} else {
+			t = t2
+		}
+	}
+
+	if t <= 0 || math.IsInf(t, 0) || math.IsNaN(t) {
+		return tx, ty
+	}
+	return tx + float64(tvx*t), ty + float64(tvy*t)
+}